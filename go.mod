@@ -1,8 +1,11 @@
 module github.com/stefaanc/golang-exec
 
-go 1.13
+go 1.18
 
 require (
 	github.com/mitchellh/go-homedir v1.1.0
 	golang.org/x/crypto v0.0.0-20191202143827-86a70503ff7e
+	golang.org/x/text v0.14.0
 )
+
+require golang.org/x/sys v0.5.0 // indirect
@@ -0,0 +1,224 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package output
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//------------------------------------------------------------------------------
+
+// the writers and readers that are shared by the local and the ssh runners
+
+//------------------------------------------------------------------------------
+
+// a writer that keeps only the first 'Size' bytes, see SetStderrInError()
+type HeadBuffer struct {
+	Size int
+
+	mutex sync.Mutex
+	data  []byte
+}
+
+func (b *HeadBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if free := b.Size - len(b.data); free > 0 {
+		if len(p) < free {
+			free = len(p)
+		}
+		b.data = append(b.data, p[:free]...)
+	}
+
+	return len(p), nil
+}
+
+func (b *HeadBuffer) Lines(n int) string {
+	// returns up to n lines, followed by "..." when there are more
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	text := strings.TrimRight(strings.ReplaceAll(string(b.data), "\r\n", "\n"), "\n")
+	if len(strings.TrimSpace(text)) == 0 {
+		return ""
+	}
+
+	lines := strings.Split(text, "\n")
+	if len(lines) > n {
+		lines = append(lines[:n], "...")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+//------------------------------------------------------------------------------
+
+// a reader whose Close() closes the runner, see StdoutReadCloser()
+type ReadCloser struct {
+	io.Reader
+
+	close  func() error
+	closed int32
+}
+
+func NewReadCloser(reader io.Reader, close func() error) *ReadCloser {
+	return &ReadCloser{Reader: reader, close: close}
+}
+
+func (rc *ReadCloser) Close() error {
+	// remark that only the first call closes the runner
+	if !atomic.CompareAndSwapInt32(&rc.closed, 0, 1) {
+		return nil
+	}
+	return rc.close()
+}
+
+//------------------------------------------------------------------------------
+
+// a channel fed with the chunks read from a pipe once the command is started, see StdoutChan()
+type Chan struct {
+	mutex   sync.Mutex
+	reader  io.Reader
+	ch      chan []byte
+	done    chan struct{}
+	started bool
+	stopped bool
+}
+
+func NewChan(reader io.Reader) *Chan {
+	return &Chan{
+		reader: reader,
+		ch:     make(chan []byte),
+		done:   make(chan struct{}),
+	}
+}
+
+func (o *Chan) C() <-chan []byte {
+	return o.ch
+}
+
+func (o *Chan) Start() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.started || o.stopped {
+		return
+	}
+	o.started = true
+
+	go o.feed()
+}
+
+func (o *Chan) feed() {
+	defer close(o.ch)
+
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := o.reader.Read(buffer)
+		if n > 0 {
+			// the consumer keeps the chunk, so it gets a copy
+			chunk := make([]byte, n)
+			copy(chunk, buffer[:n])
+
+			select {
+			case o.ch <- chunk:
+			case <-o.done:
+				return // the runner is closed, the consumer may have stopped reading
+			}
+		}
+		if err != nil {
+			return // io.EOF when the command finishes
+		}
+	}
+}
+
+func (o *Chan) Stop() {
+	// unblocks the feeding goroutine, or closes the channel when it was never started
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.stopped {
+		return
+	}
+	o.stopped = true
+
+	close(o.done)
+	if !o.started {
+		close(o.ch)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// a writer that passes a copy of every chunk to a callback, see SetStdoutCallback()
+type CallbackWriter struct {
+	Callback func([]byte)
+}
+
+func (w *CallbackWriter) Write(p []byte) (int, error) {
+	// the caller may reuse p after Write returns, so the callback gets a copy
+	chunk := make([]byte, len(p))
+	copy(chunk, p)
+	w.Callback(chunk)
+
+	return len(p), nil
+}
+
+//------------------------------------------------------------------------------
+
+// a writer that passes every line through a filter, f.i. to redact secrets, see SetOutputFilter()
+type FilterWriter struct {
+	Writer io.Writer
+	Filter func(line string) string
+
+	partial []byte
+}
+
+func (w *FilterWriter) Write(p []byte) (int, error) {
+	// the filter gets the line without its line ending, the line ending is kept
+	data := p
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			w.partial = append(w.partial, data...)
+			break
+		}
+
+		w.partial = append(w.partial, data[:i]...)
+		end := "\n"
+		if n := len(w.partial); n > 0 && w.partial[n-1] == '\r' {
+			w.partial = w.partial[:n-1]
+			end = "\r\n"
+		}
+		_, err := io.WriteString(w.Writer, w.Filter(string(w.partial))+end)
+		w.partial = w.partial[:0]
+		if err != nil {
+			return 0, err
+		}
+		data = data[i+1:]
+	}
+
+	return len(p), nil
+}
+
+func (w *FilterWriter) Flush() error {
+	// writes the last partial line
+	if len(w.partial) == 0 {
+		return nil
+	}
+
+	_, err := io.WriteString(w.Writer, w.Filter(string(w.partial)))
+	w.partial = nil
+	return err
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,50 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package output
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func TestFilterWriter(t *testing.T) {
+	// the lines are filtered as they complete, the last partial line when the writer is flushed
+	var b strings.Builder
+	w := &FilterWriter{Writer: &b, Filter: strings.ToUpper}
+
+	for _, chunk := range []string{"ab", "c\r\nd", "e\nf"} {
+		_, err := io.WriteString(w, chunk)
+		if err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+	}
+	if want := "ABC\r\nDE\n"; b.String() != want {
+		t.Errorf("output = %q, want %q", b.String(), want)
+	}
+
+	err := w.Flush()
+	if err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if want := "ABC\r\nDE\nF"; b.String() != want {
+		t.Errorf("output = %q, want %q", b.String(), want)
+	}
+}
+
+func TestHeadBuffer(t *testing.T) {
+	b := &HeadBuffer{Size: 8}
+	_, _ = io.WriteString(b, "1\r\n2\n3\n4\n5\n")
+
+	if want := "1\n2\n..."; b.Lines(2) != want {
+		t.Errorf("Lines(2) = %q, want %q", b.Lines(2), want)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,324 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package sshtest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+//------------------------------------------------------------------------------
+
+// an in-process ssh server, to exercise the runners end-to-end without a real ssh endpoint
+//
+// the server accepts password authentication for a single user, and executes "exec" and "shell" requests
+// using the 'Exec' handler - by default, the command is run locally using "sh -c", a shell runs "sh"
+type Server struct {
+	Host     string
+	Port     uint16
+	User     string
+	Password string
+
+	Exec       func(command string, env []string, stdin io.Reader, stdout, stderr io.Writer) int // returns the exit code, 'env' holds the "env" requests as "NAME=value"
+	RejectExec bool                                                                              // rejects "exec" requests, like a server with a restricted 'ForceCommand'
+	Motd       string                                                                            // printed at the start of "shell" requests, like the message of the day
+	ForwardTCP bool                                                                              // accepts "direct-tcpip" channels, like a jump host
+	AuthDelay  time.Duration                                                                     // delays the password check, f.i. to exceed the handshake timeout of the client
+	RejectAuth int                                                                               // rejects the first password checks, f.i. to fail the first attempt of a retry
+	DropGlobal bool                                                                              // doesn't reply to global requests, like a dead host, f.i. to miss the keepalive requests of the client
+
+	hostKey  ssh.Signer
+	config   *ssh.ServerConfig
+	listener net.Listener
+	wg       sync.WaitGroup
+	mutex    sync.Mutex
+	conns    map[net.Conn]struct{}
+}
+
+//------------------------------------------------------------------------------
+
+func NewServer(user string, password string) (*Server, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("[golang-exec/internal/sshtest/NewServer()] cannot generate host key: %#w\n", err)
+	}
+
+	hostKey, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("[golang-exec/internal/sshtest/NewServer()] cannot create host key signer: %#w\n", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("[golang-exec/internal/sshtest/NewServer()] cannot listen: %#w\n", err)
+	}
+
+	srv := new(Server)
+	srv.Host = "127.0.0.1"
+	srv.Port = uint16(listener.Addr().(*net.TCPAddr).Port)
+	srv.User = user
+	srv.Password = password
+	srv.Exec = execLocal
+	srv.hostKey = hostKey
+	srv.listener = listener
+	srv.conns = make(map[net.Conn]struct{})
+
+	srv.config = &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			time.Sleep(srv.AuthDelay)
+
+			srv.mutex.Lock()
+			reject := srv.RejectAuth > 0
+			if reject {
+				srv.RejectAuth--
+			}
+			srv.mutex.Unlock()
+			if reject {
+				return nil, errors.New("authentication rejected")
+			}
+
+			if conn.User() == srv.User && string(password) == srv.Password {
+				return nil, nil
+			}
+			return nil, errors.New("invalid user or password")
+		},
+	}
+	srv.config.AddHostKey(hostKey)
+
+	srv.wg.Add(1)
+	go srv.serve()
+
+	return srv, nil
+}
+
+func (srv *Server) HostKey() ssh.PublicKey {
+	return srv.hostKey.PublicKey()
+}
+
+func (srv *Server) Close() error {
+	// closes the listener and the open connections, f.i. of a control master that is still serving
+	err := srv.listener.Close()
+	srv.CloseConnections()
+	srv.wg.Wait()
+
+	return err
+}
+
+func (srv *Server) CloseConnections() {
+	// closes the open connections, but keeps listening, f.i. to test a lost connection
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+
+	for conn := range srv.conns {
+		conn.Close()
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func (srv *Server) serve() {
+	defer srv.wg.Done()
+
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			return // listener closed
+		}
+
+		srv.mutex.Lock()
+		srv.conns[conn] = struct{}{}
+		srv.mutex.Unlock()
+
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			srv.handleConn(conn)
+
+			srv.mutex.Lock()
+			delete(srv.conns, conn)
+			srv.mutex.Unlock()
+		}()
+	}
+}
+
+func (srv *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, srv.config)
+	if err != nil {
+		return // handshake or authentication failed
+	}
+	if srv.DropGlobal {
+		go func() {
+			for range reqs {
+			}
+		}()
+	} else {
+		go ssh.DiscardRequests(reqs)
+	}
+
+	var wg sync.WaitGroup
+	for newChannel := range chans {
+		if newChannel.ChannelType() == "direct-tcpip" && srv.ForwardTCP {
+			wg.Add(1)
+			go func(newChannel ssh.NewChannel) {
+				defer wg.Done()
+				forwardTCP(newChannel)
+			}(newChannel)
+			continue
+		}
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			srv.handleSession(channel, requests)
+		}()
+	}
+	wg.Wait()
+}
+
+func (srv *Server) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	var env []string
+	for req := range requests {
+		switch req.Type {
+		case "exec", "shell":
+			if req.Type == "exec" && srv.RejectExec {
+				_ = req.Reply(false, nil)
+				continue
+			}
+
+			command := "exec sh"
+			if req.Type == "exec" {
+				var ok bool
+				command, ok = parseString(req.Payload)
+				if !ok {
+					_ = req.Reply(false, nil)
+					continue
+				}
+			}
+			_ = req.Reply(true, nil)
+
+			if req.Type == "shell" && len(srv.Motd) > 0 {
+				_, _ = io.WriteString(channel, srv.Motd)
+			}
+
+			exitCode := srv.Exec(command, env, channel, channel, channel.Stderr())
+
+			status := make([]byte, 4)
+			binary.BigEndian.PutUint32(status, uint32(exitCode))
+			_, _ = channel.SendRequest("exit-status", false, status)
+			return
+		case "env":
+			var variable struct {
+				Name  string
+				Value string
+			}
+			err := ssh.Unmarshal(req.Payload, &variable)
+			if err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			env = append(env, variable.Name+"="+variable.Value)
+			_ = req.Reply(true, nil)
+		case "pty-req":
+			_ = req.Reply(true, nil)
+		default:
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func forwardTCP(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	err := ssh.Unmarshal(newChannel.ExtraData(), &target)
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, "invalid payload")
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, fmt.Sprint(target.Port)))
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+
+	done := make(chan struct{}, 2)
+	go func() { _, _ = io.Copy(conn, channel); done <- struct{}{} }()
+	go func() { _, _ = io.Copy(channel, conn); done <- struct{}{} }()
+	<-done
+}
+
+func execLocal(command string, env []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+		return 127
+	}
+
+	return 0
+}
+
+func parseString(payload []byte) (string, bool) {
+	// an ssh string is encoded as a uint32 length followed by the bytes
+	if len(payload) < 4 {
+		return "", false
+	}
+	length := binary.BigEndian.Uint32(payload)
+	if uint32(len(payload)-4) < length {
+		return "", false
+	}
+
+	return string(payload[4 : 4+length]), true
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,173 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package runner
+
+import (
+    "context"
+    "io"
+    "sync"
+    "time"
+
+    "github.com/stefaanc/golang-exec/script"
+)
+
+//------------------------------------------------------------------------------
+
+// an audit record describes who ran what where, and the outcome, see SetAuditHook()
+type AuditRecord struct {
+    User     string      // the user of the connection, empty for a local runner
+    Host     string      // empty for a local runner
+    Script   string      // the name of the script
+    Command  string      // the command that is executed, empty when the runner could not be created
+    ExitCode int         // -1 when runner error without completing script
+    Start    time.Time   // zero when the runner could not be created
+    End      time.Time
+    Err      error       // nil when the run succeeded
+}
+
+type auditedRunner struct {
+    Runner
+    hook   func(AuditRecord)
+    record AuditRecord
+}
+
+var auditMutex sync.RWMutex
+var auditHook func(AuditRecord)
+
+//------------------------------------------------------------------------------
+
+func SetAuditHook(hook func(AuditRecord)) {
+    // sets the hook that is called once per completed run, successful or not, for the runners created after this call
+    // the hook is also called with a partial record when New() fails, f.i. because the host rejected the credentials
+    // nil removes the hook
+    //
+    // remark that when a hook is set, the runners returned by New() are wrapped,
+    // hence these can no longer be type-asserted to '*ssh.Runner' or '*local.Runner'
+    // remark that the hook may be called concurrently, from the goroutines that run the runners
+    auditMutex.Lock()
+    auditHook = hook
+    auditMutex.Unlock()
+}
+
+func currentAuditHook() func(AuditRecord) {
+    auditMutex.RLock()
+    defer auditMutex.RUnlock()
+
+    return auditHook
+}
+
+func audit(connection interface {}, r Runner, s *script.Script) Runner {
+    // returns the runner to use instead of r, so the audit hook is called when it completes
+    hook := currentAuditHook()
+    if hook == nil {
+        return r
+    }
+
+    return &auditedRunner{
+        Runner: r,
+        hook:   hook,
+        record: AuditRecord{
+            User:    connectionUser(connection),
+            Host:    connectionHost(connection),
+            Script:  s.Name,
+            Command: r.Command(),
+        },
+    }
+}
+
+func auditNewError(connection interface {}, s *script.Script, err error) {
+    hook := currentAuditHook()
+    if hook == nil {
+        return
+    }
+
+    // remark that the script is nil when a step has no script
+    var name string
+    if s != nil {
+        name = s.Name
+    }
+
+    hook(AuditRecord{
+        User:     connectionUser(connection),
+        Host:     connectionHost(connection),
+        Script:   name,
+        ExitCode: -1,
+        End:      time.Now(),
+        Err:      err,
+    })
+}
+
+//------------------------------------------------------------------------------
+
+func (a *auditedRunner) started() {
+    a.record.Start = time.Now()
+}
+
+func (a *auditedRunner) finished(err error) {
+    record := a.record
+    record.ExitCode = a.Runner.ExitCode()
+    record.End = time.Now()
+    record.Err = err
+    a.hook(record)
+}
+
+func (a *auditedRunner) Run() error {
+    a.started()
+    err := a.Runner.Run()
+    a.finished(err)
+    return err
+}
+
+func (a *auditedRunner) RunRaw() (int, error) {
+    // remark that a non-zero exit code is not an error here, the record gets the exit code with a nil error
+    a.started()
+    exitCode, err := a.Runner.RunRaw()
+    a.finished(err)
+    return exitCode, err
+}
+
+func (a *auditedRunner) Start() error {
+    a.started()
+    err := a.Runner.Start()
+    if err != nil {
+        a.finished(err)
+    }
+    return err
+}
+
+func (a *auditedRunner) StartWithDeadline(deadline time.Time) error {
+    a.started()
+    err := a.Runner.StartWithDeadline(deadline)
+    if err != nil {
+        a.finished(err)
+    }
+    return err
+}
+
+func (a *auditedRunner) Stream(stdout io.Writer, stderr io.Writer) error {
+    a.started()
+    err := a.Runner.Stream(stdout, stderr)
+    if err != nil {
+        a.finished(err)
+    }
+    return err
+}
+
+func (a *auditedRunner) RunContextTimeout(ctx context.Context, timeout time.Duration) error {
+    a.started()
+    err := a.Runner.RunContextTimeout(ctx, timeout)
+    a.finished(err)
+    return err
+}
+
+func (a *auditedRunner) Wait() error {
+    err := a.Runner.Wait()
+    a.finished(err)
+    return err
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,82 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package runner
+
+import (
+    "errors"
+    "sync"
+    "testing"
+
+    "github.com/stefaanc/golang-exec/runner/ssh"
+)
+
+//------------------------------------------------------------------------------
+
+func setTestAuditHook(t *testing.T) func() []AuditRecord {
+    // returns a function that returns the records since the previous call
+    // remark that the hook is global, hence tests using it must not run in parallel
+    t.Helper()
+
+    var mutex sync.Mutex
+    var records []AuditRecord
+    SetAuditHook(func(record AuditRecord) {
+        mutex.Lock()
+        defer mutex.Unlock()
+        records = append(records, record)
+    })
+    t.Cleanup(func() { SetAuditHook(nil) })
+
+    return func() []AuditRecord {
+        mutex.Lock()
+        defer mutex.Unlock()
+        r := records
+        records = nil
+        return r
+    }
+}
+
+//------------------------------------------------------------------------------
+
+func TestAuditConnectionFailure(t *testing.T) {
+    // when the connection fails for several steps, the error is audited once, for the first step
+    records := setTestAuditHook(t)
+    _, c := newTestServer(t)
+    c.Password = "wrong"
+
+    steps := []Step{
+        {Script: newTestScript(t, "sh", "true\n")},
+        {Script: newTestScript(t, "sh", "true\n")},
+    }
+    steps[0].Script.Name = "first"
+    steps[1].Script.Name = "second"
+
+    run := map[string]func() error{
+        "RunAll":        func() error { _, err := RunAll(c, steps, 2); return err },
+        "RunAllInShell": func() error { _, err := RunAllInShell(c, steps); return err },
+        "RunAllWithRollback": func() error {
+            _, _, err := RunAllWithRollback(c, []TransactionStep{{Forward: steps[0]}, {Forward: steps[1]}})
+            return err
+        },
+    }
+    for name, f := range run {
+        err := f()
+        if !errors.Is(err, ssh.ErrAuthFailed) {
+            t.Errorf("%s() returned %v, want ErrAuthFailed", name, err)
+        }
+
+        r := records()
+        if len(r) != 1 {
+            t.Errorf("%s(): %d audit records, want 1", name, len(r))
+            continue
+        }
+        if r[0].Script != "first" || r[0].User != c.User || r[0].Host != c.Host || r[0].ExitCode != -1 || !errors.Is(r[0].Err, ssh.ErrAuthFailed) {
+            t.Errorf("%s(): audit record %+v, want the authentication failure of script %q", name, r[0], "first")
+        }
+    }
+}
+
+//------------------------------------------------------------------------------
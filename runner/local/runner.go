@@ -7,13 +7,20 @@
 package local
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"runtime"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/stefaanc/golang-exec/internal/output"
 	"github.com/stefaanc/golang-exec/script"
 )
 
@@ -36,7 +43,40 @@ type Runner struct {
 	cmd     *exec.Cmd
 	cancel  context.CancelFunc
 
+	stdinCloser  io.Closer
+	stdoutCloser io.Closer
+	stderrCloser io.Closer
+
+	captureStdout  bool
+	captureStderr  bool
+	capturedStdout bytes.Buffer
+	capturedStderr bytes.Buffer
+
+	hasArguments bool
+	preRunHook   func(command string) error
+
+	outputFilter  func(line string) string
+	filterWriters bool
+	filters       []*output.FilterWriter
+
+	stderrInError bool
+	stderrHead    *output.HeadBuffer
+
+	stdoutPiped bool // see captureOutput()
+	stderrPiped bool
+
+	outputChans []*output.Chan
+
+	watchdogDone chan struct{}
+	contextDone  chan struct{}
+	timedOut     int32
+	killCause    error
+
 	exitCode int
+	signal   string
+
+	startTime time.Time
+	endTime   time.Time
 }
 
 //------------------------------------------------------------------------------
@@ -60,9 +100,9 @@ func New(connection interface{}, s *script.Script, arguments interface{}) (*Runn
 
 	r := new(Runner)
 	r.script = s
-	r.command = s.Command()
+	r.hasArguments = arguments != nil
 
-	stdin, err := s.NewReader(arguments)
+	command, stdin, err := s.NewCommand(arguments)
 	if err != nil {
 		return nil, &Error{
 			script:   s,
@@ -71,11 +111,32 @@ func New(connection interface{}, s *script.Script, arguments interface{}) (*Runn
 		}
 	}
 
+	r.command = command
+
 	// create command, ready to start
 	ctx, cancel := context.WithCancel(context.Background())
-	args := strings.Split(r.command, " ")
+	args := strings.Fields(r.command)
 	var cmd *exec.Cmd
-	if args[0] == "cmd" {
+	if s.IsArgv() {
+		// executed directly, without splitting the quoted command
+		argv, err := s.RenderArgv(arguments)
+		if err != nil {
+			cancel()
+			return nil, &Error{
+				script:   s,
+				exitCode: -1,
+				err:      fmt.Errorf("[golang-exec/runner/local/New()] cannot render arguments: %#w\n", err),
+			}
+		}
+		cmd = exec.CommandContext(ctx, argv[0], argv[1:]...)
+	} else if s.Shell == "raw" || s.Shell == "exec" {
+		// the rendered command is executed by a shell, so quoted arguments, f.i. from {{ quote .X }}, stay single arguments
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", r.command)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", r.command)
+		}
+	} else if args[0] == "cmd" {
 		// cmd has argument-escaping rules that are different from other programs, so needs different treatment
 		cmd = exec.CommandContext(ctx, args[0])
 		// @elebertus no idea if the api has changed over time for this or what but syscall.SysProcAttr
@@ -95,6 +156,54 @@ func New(connection interface{}, s *script.Script, arguments interface{}) (*Runn
 
 //------------------------------------------------------------------------------
 
+func (r *Runner) SetPreRunHook(hook func(command string) error) {
+	// the hook is called with the command just before it is executed, the command is aborted when the hook returns an error
+	r.preRunHook = hook
+}
+
+func (r *Runner) SetStdinReaders(readers ...io.Reader) error {
+	// replaces the rendered script on stdin by the concatenation of the readers
+	// remark that the rendered script can be included as one of the readers, using s.NewReader()
+	if r.hasArguments {
+		return &Error{
+			script:   r.script,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/local/SetStdinReaders()] cannot combine stdin readers with script arguments\n"),
+		}
+	}
+	r.cmd.Stdin = io.MultiReader(readers...)
+
+	return nil
+}
+
+func (r *Runner) SetStdinFile(path string) error {
+	// replaces the rendered script on stdin by the contents of a local file, the file is closed when the command completes
+	if r.hasArguments {
+		return &Error{
+			script:   r.script,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/local/SetStdinFile()] cannot combine stdin file with script arguments\n"),
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return &Error{
+			script:   r.script,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/local/SetStdinFile()] cannot open stdin file: %#w\n", err),
+		}
+	}
+
+	if r.stdinCloser != nil {
+		_ = r.stdinCloser.Close()
+	}
+	_ = r.SetStdinReaders(f)
+	r.stdinCloser = f
+
+	return nil
+}
+
 func (r *Runner) SetStdoutWriter(stdout io.Writer) {
 	r.cmd.Stdout = stdout
 }
@@ -103,6 +212,131 @@ func (r *Runner) SetStderrWriter(stderr io.Writer) {
 	r.cmd.Stderr = stderr
 }
 
+func (r *Runner) SetStdoutWriteCloser(stdout io.WriteCloser) {
+	// the writer is closed when the command completes
+	r.cmd.Stdout = stdout
+	r.stdoutCloser = stdout
+}
+
+func (r *Runner) SetStderrWriteCloser(stderr io.WriteCloser) {
+	// the writer is closed when the command completes
+	r.cmd.Stderr = stderr
+	r.stderrCloser = stderr
+}
+
+func (r *Runner) SetStdoutTee(stdout io.Writer) {
+	// output is written to the writer and captured, see CapturedStdout()
+	r.SetStdoutWriter(stdout)
+	r.captureStdout = true
+}
+
+func (r *Runner) SetStderrTee(stderr io.Writer) {
+	// output is written to the writer and captured, see CapturedStderr()
+	r.SetStderrWriter(stderr)
+	r.captureStderr = true
+}
+
+func (r *Runner) SetCaptureOutput(capture bool) {
+	// output is captured, in addition to being written to the writers, see CapturedStdout() and CapturedStderr()
+	// remark that the captured output is kept in memory as long as the runner is referenced, avoid this for large output
+	// remark that the output to StdoutPipe() or StderrPipe() is not captured
+	r.captureStdout = capture
+	r.captureStderr = capture
+}
+
+func (r *Runner) SetStderrInError(include bool) {
+	// the first lines of stderr are appended to the message of the error when the command fails, up to 10 lines
+	// remark that the lines are passed through the output filter, see SetOutputFilter()
+	// remark that this doesn't work with StderrPipe()
+	r.stderrInError = include
+}
+
+func (r *Runner) stderrForError() string {
+	if r.stderrHead == nil {
+		return ""
+	}
+
+	lines := r.stderrHead.Lines(10)
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return "stderr: \n" + lines + "\n"
+}
+
+func (r *Runner) SetOutputFilter(filter func(line string) string, writers bool) {
+	// every line of the captured output is passed through the filter, f.i. to redact secrets, see SetCaptureOutput()
+	// when writers is true, the output to the writers and the callbacks is filtered as well, the output to pipes is not
+	// remark that a filtered callback gets whole lines instead of the chunks as they arrive, see SetStdoutCallback()
+	// remark that the output is filtered per line, so a partial line is held back until its newline arrives
+	r.outputFilter = filter
+	r.filterWriters = writers
+}
+
+func (r *Runner) filterWriter(writer io.Writer) io.Writer {
+	w := &output.FilterWriter{Writer: writer, Filter: r.outputFilter}
+	r.filters = append(r.filters, w)
+
+	return w
+}
+
+func (r *Runner) captureOutput() {
+	// the pipes of StdoutPipe() & StderrPipe() are *os.File's that are closed after the command starts,
+	// hence these cannot be wrapped - the output to pipes is not captured nor filtered
+	if r.outputFilter != nil && r.filterWriters {
+		if r.cmd.Stdout != nil && !r.stdoutPiped {
+			r.cmd.Stdout = r.filterWriter(r.cmd.Stdout)
+		}
+		if r.cmd.Stderr != nil && !r.stderrPiped {
+			r.cmd.Stderr = r.filterWriter(r.cmd.Stderr)
+		}
+	}
+
+	var capturedStdout, capturedStderr io.Writer = &r.capturedStdout, &r.capturedStderr
+	if r.outputFilter != nil && !r.filterWriters {
+		capturedStdout = r.filterWriter(capturedStdout)
+		capturedStderr = r.filterWriter(capturedStderr)
+	}
+
+	if r.captureStdout && !r.stdoutPiped {
+		if r.cmd.Stdout == nil {
+			r.cmd.Stdout = capturedStdout
+		} else {
+			r.cmd.Stdout = io.MultiWriter(r.cmd.Stdout, capturedStdout)
+		}
+	}
+	if r.captureStderr && !r.stderrPiped {
+		if r.cmd.Stderr == nil {
+			r.cmd.Stderr = capturedStderr
+		} else {
+			r.cmd.Stderr = io.MultiWriter(r.cmd.Stderr, capturedStderr)
+		}
+	}
+
+	if r.stderrInError && !r.stderrPiped {
+		r.stderrHead = &output.HeadBuffer{Size: 4096}
+		var head io.Writer = r.stderrHead
+		if r.outputFilter != nil {
+			head = r.filterWriter(head)
+		}
+		if r.cmd.Stderr == nil {
+			r.cmd.Stderr = head
+		} else {
+			r.cmd.Stderr = io.MultiWriter(r.cmd.Stderr, head)
+		}
+	}
+}
+
+func (r *Runner) SetStdoutCallback(f func([]byte)) {
+	// remark that chunks are forwarded as they arrive, without line buffering, unless the output is filtered, see SetOutputFilter()
+	r.cmd.Stdout = &output.CallbackWriter{Callback: f}
+}
+
+func (r *Runner) SetStderrCallback(f func([]byte)) {
+	// remark that chunks are forwarded as they arrive, without line buffering, unless the output is filtered, see SetOutputFilter()
+	r.cmd.Stderr = &output.CallbackWriter{Callback: f}
+}
+
 func (r *Runner) StdoutPipe() (io.Reader, error) {
 	reader, err := r.cmd.StdoutPipe()
 	if err != nil {
@@ -113,6 +347,7 @@ func (r *Runner) StdoutPipe() (io.Reader, error) {
 			err:      fmt.Errorf("[golang-exec/runner/local/StdoutPipe()] cannot create stdout reader: %#w\n", err),
 		}
 	}
+	r.stdoutPiped = true
 
 	return reader, nil
 }
@@ -127,21 +362,103 @@ func (r *Runner) StderrPipe() (io.Reader, error) {
 			err:      fmt.Errorf("[golang-exec/runner/local/StderrPipe()] cannot create stderr reader: %#w\n", err),
 		}
 	}
+	r.stderrPiped = true
 
 	return reader, nil
 }
 
+func (r *Runner) StdoutReadCloser() (io.ReadCloser, error) {
+	// returns the reader of StdoutPipe(), closing it closes the runner, f.i. when the client of a streaming server disconnects
+	// remark that closing the reader before the script completes kills the script, use in combination with Start() & Wait()
+	reader, err := r.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	return output.NewReadCloser(reader, r.Close), nil
+}
+
+func (r *Runner) StderrReadCloser() (io.ReadCloser, error) {
+	// returns the reader of StderrPipe(), closing it closes the runner, see StdoutReadCloser()
+	reader, err := r.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	return output.NewReadCloser(reader, r.Close), nil
+}
+
+func (r *Runner) StdoutChan() (<-chan []byte, error) {
+	// returns a channel that gets the chunks of stdout once the runner is started, and that is closed when the command finishes
+	// f.i. for a select-loop in a reactive UI, use in combination with Start() & Wait()
+	// remark that the channel must be read until it is closed before calling Wait(), as for StdoutPipe()
+	// remark that Close() unblocks the goroutine that feeds the channel, hence the consumer may stop reading after Close()
+	reader, err := r.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	o := output.NewChan(reader)
+	r.outputChans = append(r.outputChans, o)
+
+	return o.C(), nil
+}
+
+func (r *Runner) StderrChan() (<-chan []byte, error) {
+	// returns a channel that gets the chunks of stderr once the runner is started, see StdoutChan()
+	reader, err := r.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	o := output.NewChan(reader)
+	r.outputChans = append(r.outputChans, o)
+
+	return o.C(), nil
+}
+
 func (r *Runner) Run() error {
+	if r.preRunHook != nil {
+		err := r.preRunHook(r.command)
+		if err != nil {
+			_ = r.closeStreams()
+			r.exitCode = -1
+			return &Error{
+				script:   r.script,
+				command:  r.command,
+				exitCode: r.exitCode,
+				err:      fmt.Errorf("[golang-exec/runner/local/Run()] command rejected by pre-run hook: %#w\n", err),
+			}
+		}
+	}
+
+	r.captureOutput()
+	r.startTime = time.Now()
 	err := r.cmd.Run()
+	r.endTime = time.Now()
+	r.stopContextWatch()
+	closeErr := r.closeStreams()
 	if err != nil {
 		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && atomic.LoadInt32(&r.timedOut) == 1 {
+			// killed using CloseOnContext()
+			r.exitCode = exitErr.ProcessState.ExitCode()
+			r.signal = signalName(exitErr.ProcessState)
+			return &Error{
+				script:   r.script,
+				command:  r.command,
+				exitCode: r.exitCode,
+				err:      fmt.Errorf("[golang-exec/runner/local/Run()] runner killed, %v (%v): %#w\n", r.killCause, err, r.killCause),
+			}
+		}
 		if errors.As(err, &exitErr) {
 			r.exitCode = exitErr.ProcessState.ExitCode()
+			r.signal = signalName(exitErr.ProcessState)
 			return &Error{
 				script:   r.script,
 				command:  r.command,
 				exitCode: r.exitCode,
-				err:      fmt.Errorf("[golang-exec/runner/local/Run()] runner failed: %#w\n", err),
+				err:      fmt.Errorf("[golang-exec/runner/local/Run()] runner failed: %#w\n%s", err, r.stderrForError()),
 			}
 		} else {
 			r.exitCode = -1
@@ -154,12 +471,51 @@ func (r *Runner) Run() error {
 		}
 	}
 
+	if closeErr != nil {
+		return &Error{
+			script:   r.script,
+			command:  r.command,
+			exitCode: r.exitCode,
+			err:      fmt.Errorf("[golang-exec/runner/local/Run()] cannot close output writers: %#w\n", closeErr),
+		}
+	}
+
 	return nil
 }
 
+func (r *Runner) RunRaw() (int, error) {
+	// same as Run(), but the command exiting with a non-zero exit code is not an error
+	// the error is reserved for failing to run the command, or the command being killed by a signal
+	err := r.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(r.signal) == 0 {
+		return r.exitCode, nil
+	}
+
+	return r.exitCode, err
+}
+
 func (r *Runner) Start() error {
+	if r.preRunHook != nil {
+		err := r.preRunHook(r.command)
+		if err != nil {
+			_ = r.closeStreams()
+			r.exitCode = -1
+			return &Error{
+				script:   r.script,
+				command:  r.command,
+				exitCode: r.exitCode,
+				err:      fmt.Errorf("[golang-exec/runner/local/Start()] command rejected by pre-run hook: %#w\n", err),
+			}
+		}
+	}
+
+	r.captureOutput()
+	r.startTime = time.Now()
 	err := r.cmd.Start()
 	if err != nil {
+		r.startTime = time.Time{}
+		_ = r.closeStreams()
 		r.exitCode = -1
 		return &Error{
 			script:   r.script,
@@ -169,31 +525,161 @@ func (r *Runner) Start() error {
 		}
 	}
 
+	for _, o := range r.outputChans {
+		o.Start()
+	}
+
 	return nil
 }
 
+func (r *Runner) Stream(stdout io.Writer, stderr io.Writer) error {
+	// starts the command, stdout and stderr are copied to the writers concurrently, and Wait() returns once both are copied
+	// remark that reading the readers from StdoutPipe() & StderrPipe() one after the other deadlocks when the command
+	// fills the buffer of the other one, this avoids that - a nil writer discards the output
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	r.SetStdoutWriter(stdout)
+	r.SetStderrWriter(stderr)
+
+	return r.Start()
+}
+
+func (r *Runner) StartWithDeadline(deadline time.Time) error {
+	// starts the runner, and kills the command when Wait() didn't return before the deadline
+	err := r.Start()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	r.watchdogDone = done
+	go func() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+
+		select {
+		case <-done:
+		case <-timer.C:
+			atomic.StoreInt32(&r.timedOut, 1)
+			r.cancel()
+		}
+	}()
+
+	return nil
+}
+
+func (r *Runner) RunContextTimeout(ctx context.Context, timeout time.Duration) error {
+	// runs the runner, and kills the command when the parent context is done or the timeout expires, whichever comes first
+	// remark that the error reports which of both killed the command
+	child, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := r.Start()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	r.watchdogDone = done
+	go func() {
+		select {
+		case <-done:
+		case <-child.Done():
+			if ctx.Err() != nil {
+				r.killCause = fmt.Errorf("parent context done: %w", ctx.Err())
+			} else {
+				r.killCause = fmt.Errorf("timeout of %v expired: %w", timeout, context.DeadlineExceeded)
+			}
+			atomic.StoreInt32(&r.timedOut, 1)
+			r.cancel()
+		}
+	}()
+
+	return r.Wait()
+}
+
+func (r *Runner) CloseOnContext(ctx context.Context) {
+	// kills the command when the context is done, use in combination with Start() & Wait() or with Run()
+	// remark that the goroutine watching the context stops when Run() or Wait() returns or the runner is closed
+	r.stopContextWatch()
+
+	done := make(chan struct{})
+	r.contextDone = done
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			r.killCause = fmt.Errorf("context done: %w", ctx.Err())
+			atomic.StoreInt32(&r.timedOut, 1)
+			r.cancel()
+		}
+	}()
+}
+
 func (r *Runner) Wait() error {
 	err := r.cmd.Wait()
+	r.endTime = time.Now()
+	r.stopWatchdog()
+	r.stopContextWatch()
+	closeErr := r.closeStreams()
 	if err != nil {
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
 			r.exitCode = exitErr.ProcessState.ExitCode()
+			r.signal = signalName(exitErr.ProcessState)
 		} else {
 			r.exitCode = -1
 		}
+		if atomic.LoadInt32(&r.timedOut) == 1 && r.killCause != nil {
+			return &Error{
+				script:   r.script,
+				command:  r.command,
+				exitCode: r.exitCode,
+				err:      fmt.Errorf("[golang-exec/runner/local/Wait()] runner killed, %v (%v): %#w\n", r.killCause, err, r.killCause),
+			}
+		}
+		if atomic.LoadInt32(&r.timedOut) == 1 {
+			return &Error{
+				script:   r.script,
+				command:  r.command,
+				exitCode: r.exitCode,
+				err:      fmt.Errorf("[golang-exec/runner/local/Wait()] runner killed after deadline (%v): %#w\n", err, context.DeadlineExceeded),
+			}
+		}
 		return &Error{
 			script:   r.script,
 			command:  r.command,
 			exitCode: r.exitCode,
-			err:      fmt.Errorf("[golang-exec/runner/local/Wait()] runner failed: %#w\n", err),
+			err:      fmt.Errorf("[golang-exec/runner/local/Wait()] runner failed: %#w\n%s", err, r.stderrForError()),
 		}
 	}
 
 	r.exitCode = 0
+	if closeErr != nil {
+		return &Error{
+			script:   r.script,
+			command:  r.command,
+			exitCode: r.exitCode,
+			err:      fmt.Errorf("[golang-exec/runner/local/Wait()] cannot close output writers: %#w\n", closeErr),
+		}
+	}
+
 	return nil
 }
 
 func (r *Runner) Close() error {
+	r.stopWatchdog()
+	r.stopContextWatch()
+	_ = r.closeStreams()
+
+	for _, o := range r.outputChans {
+		o.Stop()
+	}
+
 	if r.cancel != nil {
 		r.cancel()
 	}
@@ -201,8 +687,126 @@ func (r *Runner) Close() error {
 	return nil
 }
 
+func (r *Runner) stopContextWatch() {
+	if r.contextDone != nil {
+		close(r.contextDone)
+		r.contextDone = nil
+	}
+}
+
+func (r *Runner) stopWatchdog() {
+	if r.watchdogDone != nil {
+		close(r.watchdogDone)
+		r.watchdogDone = nil
+	}
+}
+
+func (r *Runner) closeStreams() error {
+	// closes the file set using SetStdinFile() and the writers set using SetStdoutWriteCloser() & SetStderrWriteCloser(), only once
+	// remark that errors from closing stdin are ignored, it is only read from
+	if r.stdinCloser != nil {
+		_ = r.stdinCloser.Close()
+		r.stdinCloser = nil
+	}
+
+	// flush the filters before closing the writers they write to
+	var err error
+	for _, w := range r.filters {
+		if e := w.Flush(); e != nil && err == nil {
+			err = e
+		}
+	}
+	r.filters = nil
+
+	if r.stdoutCloser != nil {
+		if e := r.stdoutCloser.Close(); e != nil && err == nil {
+			err = e
+		}
+		r.stdoutCloser = nil
+	}
+	if r.stderrCloser != nil {
+		if e := r.stderrCloser.Close(); e != nil && err == nil {
+			err = e
+		}
+		r.stderrCloser = nil
+	}
+
+	return err
+}
+
+func (r *Runner) DetectPlatform() (string, string, error) {
+	return runtime.GOOS, runtime.GOARCH, nil
+}
+
+func (r *Runner) CapturedStdout() string {
+	// remark that the captured output is only complete after Run() or Wait() returns
+	return r.capturedStdout.String()
+}
+
+func (r *Runner) CapturedStderr() string {
+	// remark that the captured output is only complete after Run() or Wait() returns
+	return r.capturedStderr.String()
+}
+
+func (r *Runner) Command() string {
+	// returns the command that is executed, including any wrapping
+	return r.command
+}
+
+func (r *Runner) Status() (int, string, bool) {
+	// returns the exit code, or the name of the signal that killed the command
+	// ok is false when there is no exit code, because the command was killed by a signal or didn't complete
+	if len(r.signal) > 0 {
+		return -1, r.signal, false
+	}
+	return r.exitCode, "", r.exitCode >= 0
+}
+
+// names of the signals, consistent with the names reported by a ssh host
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGALRM: "SIGALRM",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGTERM: "SIGTERM",
+}
+
+func signalName(state *os.ProcessState) string {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+
+	if name, ok := signalNames[status.Signal()]; ok {
+		return name
+	}
+	return status.Signal().String()
+}
+
 func (r *Runner) ExitCode() int {
 	return r.exitCode
 }
 
+func (r *Runner) Duration() time.Duration {
+	// returns the time from starting the command until Run() or Wait() returned, or until now when it is still running
+	// remark that this is 0 when the command didn't start
+	if r.startTime.IsZero() {
+		return 0
+	}
+	if r.endTime.IsZero() {
+		return time.Since(r.startTime)
+	}
+	return r.endTime.Sub(r.startTime)
+}
+
+func (r *Runner) StderrMerged() bool {
+	return false
+}
+
 //------------------------------------------------------------------------------
@@ -0,0 +1,111 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package local
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stefaanc/golang-exec/script"
+)
+
+//------------------------------------------------------------------------------
+
+func newTestScript(t *testing.T, shell string, code string) *script.Script {
+	t.Helper()
+
+	s, err := script.NewFromString("test", shell, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+//------------------------------------------------------------------------------
+
+func TestCaptureOutputWithStdoutPipe(t *testing.T) {
+	// the pipe is not captured, but it must keep working
+	r, err := New(Connection{Type: "local"}, newTestScript(t, "sh", "echo out\necho err >&2\n"), nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+
+	r.SetCaptureOutput(true)
+	stdout, err := r.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe() failed: %v", err)
+	}
+
+	err = r.Start()
+	if err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	output, err := io.ReadAll(stdout)
+	if err != nil {
+		t.Fatalf("reading the pipe failed: %v", err)
+	}
+	err = r.Wait()
+	if err != nil {
+		t.Fatalf("Wait() failed: %v", err)
+	}
+
+	if string(output) != "out\n" {
+		t.Errorf("stdout = %q, want %q", string(output), "out\n")
+	}
+	if r.CapturedStdout() != "" {
+		t.Errorf("CapturedStdout() = %q, want %q", r.CapturedStdout(), "")
+	}
+	if r.CapturedStderr() != "err\n" {
+		t.Errorf("CapturedStderr() = %q, want %q", r.CapturedStderr(), "err\n")
+	}
+}
+
+func TestOutputFilterWithCallback(t *testing.T) {
+	// when the writers are filtered, the callbacks are filtered too
+	r, err := New(Connection{Type: "local"}, newTestScript(t, "sh", "echo password=secret\necho done\n"), nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+
+	var output []byte
+	r.SetStdoutCallback(func(chunk []byte) { output = append(output, chunk...) })
+	r.SetOutputFilter(func(line string) string { return strings.ReplaceAll(line, "secret", "***") }, true)
+
+	err = r.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if want := "password=***\ndone\n"; string(output) != want {
+		t.Errorf("stdout = %q, want %q", string(output), want)
+	}
+}
+
+func TestRawQuotedArguments(t *testing.T) {
+	// the rendered command is executed by a shell, so a quoted argument with spaces stays a single argument
+	s := newTestScript(t, "raw", "printf '[%s]' {{ quote .A }} {{ quote .B }}  end")
+
+	r, err := New(Connection{Type: "local"}, s, map[string]string{"A": "two words", "B": "it's"})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+	r.SetCaptureOutput(true)
+
+	err = r.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if want := "[two words][it's][end]"; r.CapturedStdout() != want {
+		t.Errorf("stdout = %q, want %q", r.CapturedStdout(), want)
+	}
+}
+
+//------------------------------------------------------------------------------
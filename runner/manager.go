@@ -0,0 +1,141 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package runner
+
+import (
+    "context"
+    "io"
+    "sync"
+    "time"
+)
+
+//------------------------------------------------------------------------------
+
+// a manager keeps track of active runners, to close them all f.i. on shutdown
+//
+// remark that a runner is no longer tracked when Run(), RunRaw(), Wait() or RunContextTimeout() returns, or when it is closed
+type Manager struct {
+    mutex   sync.Mutex
+    runners map[*trackedRunner]struct{}
+}
+
+type trackedRunner struct {
+    Runner
+    manager *Manager
+}
+
+//------------------------------------------------------------------------------
+
+func NewManager() *Manager {
+    m := new(Manager)
+    m.runners = make(map[*trackedRunner]struct{})
+
+    return m
+}
+
+func (m *Manager) Track(r Runner) Runner {
+    // returns the runner to use instead of r, so the manager knows when it finishes
+    t := &trackedRunner{Runner: r, manager: m}
+
+    m.mutex.Lock()
+    m.runners[t] = struct{}{}
+    m.mutex.Unlock()
+
+    return t
+}
+
+func (m *Manager) Count() int {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+
+    return len(m.runners)
+}
+
+func (m *Manager) CloseAll() error {
+    // closes all tracked runners, returns the first error
+    m.mutex.Lock()
+    runners := make([]*trackedRunner, 0, len(m.runners))
+    for t := range m.runners {
+        runners = append(runners, t)
+    }
+    m.mutex.Unlock()
+
+    var err error
+    for _, t := range runners {
+        if e := t.Close(); e != nil && err == nil {
+            err = e
+        }
+    }
+
+    return err
+}
+
+func (m *Manager) untrack(t *trackedRunner) {
+    m.mutex.Lock()
+    delete(m.runners, t)
+    m.mutex.Unlock()
+}
+
+//------------------------------------------------------------------------------
+
+func (t *trackedRunner) Run() error {
+    defer t.manager.untrack(t)
+    return t.Runner.Run()
+}
+
+func (t *trackedRunner) RunRaw() (int, error) {
+    defer t.manager.untrack(t)
+    return t.Runner.RunRaw()
+}
+
+func (t *trackedRunner) Wait() error {
+    defer t.manager.untrack(t)
+    return t.Runner.Wait()
+}
+
+func (t *trackedRunner) RunContextTimeout(ctx context.Context, timeout time.Duration) error {
+    defer t.manager.untrack(t)
+    return t.Runner.RunContextTimeout(ctx, timeout)
+}
+
+func (t *trackedRunner) Close() error {
+    defer t.manager.untrack(t)
+    return t.Runner.Close()
+}
+
+func (t *trackedRunner) StdoutReadCloser() (io.ReadCloser, error) {
+    // the reader closes the tracked runner, so it is no longer tracked when the reader is closed
+    reader, err := t.Runner.StdoutPipe()
+    if err != nil {
+        return nil, err
+    }
+    return &readCloser{Reader: reader, closer: t}, nil
+}
+
+func (t *trackedRunner) StderrReadCloser() (io.ReadCloser, error) {
+    reader, err := t.Runner.StderrPipe()
+    if err != nil {
+        return nil, err
+    }
+    return &readCloser{Reader: reader, closer: t}, nil
+}
+
+//------------------------------------------------------------------------------
+
+type readCloser struct {
+    io.Reader
+    closer io.Closer
+    once   sync.Once
+    err    error
+}
+
+func (rc *readCloser) Close() error {
+    rc.once.Do(func() { rc.err = rc.closer.Close() })
+    return rc.err
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,165 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package runner
+
+import (
+    "context"
+    "errors"
+    "io"
+    "sync"
+    "time"
+
+    "github.com/stefaanc/golang-exec/runner/ssh"
+)
+
+//------------------------------------------------------------------------------
+
+// an observer is called at the key points in the lifecycle of the runners, f.i. to count runs, failures,
+// authentication failures and timeouts in a metrics backend, see SetObserver()
+//
+// remark that the methods may be called concurrently, from the goroutines that run the runners
+type Observer interface {
+    RunStarted(host string, script string)   // the host is empty for a local runner
+    RunFinished(host string, script string, exitCode int, err error, duration time.Duration)   // err is nil when the run succeeded
+    AuthFailed(host string, err error)   // the runner could not be created because the host rejected the credentials
+    TimedOut(host string, script string, err error)   // called before RunFinished() when the command was killed after a deadline, a timeout or an 'IdleTimeout'
+    ConnectionLost(host string, script string, err error)   // called before RunFinished() when the host didn't reply to the keepalive requests, see 'KeepAliveInterval'
+}
+
+// an observer that does nothing, the default
+type NopObserver struct{}
+
+type observedRunner struct {
+    Runner
+    observer Observer
+    host     string
+    script   string
+    start    time.Time
+}
+
+var observerMutex sync.RWMutex
+var observer Observer = NopObserver{}
+
+//------------------------------------------------------------------------------
+
+func (NopObserver) RunStarted(host string, script string)                                              {}
+func (NopObserver) RunFinished(host string, script string, exitCode int, err error, d time.Duration) {}
+func (NopObserver) AuthFailed(host string, err error)                                                {}
+func (NopObserver) TimedOut(host string, script string, err error)                                   {}
+func (NopObserver) ConnectionLost(host string, script string, err error)                             {}
+
+//------------------------------------------------------------------------------
+
+func SetObserver(o Observer) {
+    // sets the observer for the runners created after this call, nil restores the default 'NopObserver'
+    // remark that when an observer is set, the runners returned by New() are wrapped,
+    // hence these can no longer be type-asserted to '*ssh.Runner' or '*local.Runner'
+    if o == nil {
+        o = NopObserver{}
+    }
+
+    observerMutex.Lock()
+    observer = o
+    observerMutex.Unlock()
+}
+
+func currentObserver() Observer {
+    observerMutex.RLock()
+    defer observerMutex.RUnlock()
+
+    return observer
+}
+
+func observe(connection interface {}, r Runner, scriptName string) Runner {
+    // returns the runner to use instead of r, so the observer is called when it runs
+    o := currentObserver()
+    if _, ok := o.(NopObserver); ok {
+        return r
+    }
+
+    return &observedRunner{Runner: r, observer: o, host: connectionHost(connection), script: scriptName}
+}
+
+func observeNewError(connection interface {}, err error) {
+    if errors.Is(err, ssh.ErrAuthFailed) {
+        currentObserver().AuthFailed(connectionHost(connection), err)
+    }
+}
+
+//------------------------------------------------------------------------------
+
+func (o *observedRunner) started() {
+    o.start = time.Now()
+    o.observer.RunStarted(o.host, o.script)
+}
+
+func (o *observedRunner) finished(err error) {
+    switch {
+    case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ssh.ErrIdleTimeout):
+        o.observer.TimedOut(o.host, o.script, err)
+    case errors.Is(err, ssh.ErrConnectionLost):
+        o.observer.ConnectionLost(o.host, o.script, err)
+    }
+    o.observer.RunFinished(o.host, o.script, o.Runner.ExitCode(), err, time.Since(o.start))
+}
+
+func (o *observedRunner) Run() error {
+    o.started()
+    err := o.Runner.Run()
+    o.finished(err)
+    return err
+}
+
+func (o *observedRunner) RunRaw() (int, error) {
+    // remark that a non-zero exit code is not an error here, the observer gets the exit code with a nil error
+    o.started()
+    exitCode, err := o.Runner.RunRaw()
+    o.finished(err)
+    return exitCode, err
+}
+
+func (o *observedRunner) Start() error {
+    o.started()
+    err := o.Runner.Start()
+    if err != nil {
+        o.finished(err)
+    }
+    return err
+}
+
+func (o *observedRunner) StartWithDeadline(deadline time.Time) error {
+    o.started()
+    err := o.Runner.StartWithDeadline(deadline)
+    if err != nil {
+        o.finished(err)
+    }
+    return err
+}
+
+func (o *observedRunner) Stream(stdout io.Writer, stderr io.Writer) error {
+    o.started()
+    err := o.Runner.Stream(stdout, stderr)
+    if err != nil {
+        o.finished(err)
+    }
+    return err
+}
+
+func (o *observedRunner) RunContextTimeout(ctx context.Context, timeout time.Duration) error {
+    o.started()
+    err := o.Runner.RunContextTimeout(ctx, timeout)
+    o.finished(err)
+    return err
+}
+
+func (o *observedRunner) Wait() error {
+    err := o.Runner.Wait()
+    o.finished(err)
+    return err
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,191 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package runner
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+//------------------------------------------------------------------------------
+
+// an observer that records its calls, one line per call
+type recordingObserver struct {
+    mutex sync.Mutex
+    calls []string
+}
+
+func (o *recordingObserver) record(format string, a ...interface{}) {
+    o.mutex.Lock()
+    defer o.mutex.Unlock()
+    o.calls = append(o.calls, fmt.Sprintf(format, a...))
+}
+
+func (o *recordingObserver) RunStarted(host string, script string) {
+    o.record("RunStarted(%q, %q)", host, script)
+}
+
+func (o *recordingObserver) RunFinished(host string, script string, exitCode int, err error, d time.Duration) {
+    o.record("RunFinished(%q, %q, %d, %v)", host, script, exitCode, err != nil)
+}
+
+func (o *recordingObserver) AuthFailed(host string, err error) {
+    o.record("AuthFailed(%q)", host)
+}
+
+func (o *recordingObserver) TimedOut(host string, script string, err error) {
+    o.record("TimedOut(%q, %q)", host, script)
+}
+
+func (o *recordingObserver) ConnectionLost(host string, script string, err error) {
+    o.record("ConnectionLost(%q, %q)", host, script)
+}
+
+func (o *recordingObserver) reset() []string {
+    o.mutex.Lock()
+    defer o.mutex.Unlock()
+    calls := o.calls
+    o.calls = nil
+    return calls
+}
+
+func setTestObserver(t *testing.T) *recordingObserver {
+    // remark that the observer is global, hence tests using it must not run in parallel
+    t.Helper()
+
+    o := new(recordingObserver)
+    SetObserver(o)
+    t.Cleanup(func() { SetObserver(nil) })
+
+    return o
+}
+
+//------------------------------------------------------------------------------
+
+func TestObserver(t *testing.T) {
+    o := setTestObserver(t)
+    local := map[string]string{"Type": "local"}
+
+    _, idle := newTestServer(t)
+    idle.IdleTimeout = 200 * time.Millisecond
+
+    srv, lost := newTestServer(t)
+    srv.DropGlobal = true
+    lost.KeepAliveInterval = 50 * time.Millisecond
+    lost.KeepAliveMaxMissed = 2
+
+    tests := []struct {
+        name       string
+        connection interface{}
+        code       string
+        run        func(Runner) error
+        calls      []string
+    }{
+        {
+            "Run", local, "true\n",
+            func(r Runner) error { return r.Run() },
+            []string{`RunStarted("", "test")`, `RunFinished("", "test", 0, false)`},
+        },
+        {
+            "Run failing", local, "exit 3\n",
+            func(r Runner) error { return r.Run() },
+            []string{`RunStarted("", "test")`, `RunFinished("", "test", 3, true)`},
+        },
+        {
+            "RunRaw failing", local, "exit 3\n",
+            func(r Runner) error { _, err := r.RunRaw(); return err },
+            []string{`RunStarted("", "test")`, `RunFinished("", "test", 3, false)`},
+        },
+        {
+            "Start and Wait", local, "true\n",
+            func(r Runner) error {
+                err := r.Start()
+                if err != nil {
+                    return err
+                }
+                return r.Wait()
+            },
+            []string{`RunStarted("", "test")`, `RunFinished("", "test", 0, false)`},
+        },
+        {
+            "Stream and Wait", local, "echo out\n",
+            func(r Runner) error {
+                err := r.Stream(io.Discard, io.Discard)
+                if err != nil {
+                    return err
+                }
+                return r.Wait()
+            },
+            []string{`RunStarted("", "test")`, `RunFinished("", "test", 0, false)`},
+        },
+        {
+            "RunContextTimeout", local, "sleep 10\n",
+            func(r Runner) error { return r.RunContextTimeout(context.Background(), 100*time.Millisecond) },
+            []string{`RunStarted("", "test")`, `TimedOut("", "test")`, `RunFinished("", "test", -1, true)`},
+        },
+        {
+            "IdleTimeout", idle, "sleep 1\n",
+            func(r Runner) error { return r.Run() },
+            []string{`RunStarted("127.0.0.1", "test")`, `TimedOut("127.0.0.1", "test")`, `RunFinished("127.0.0.1", "test", -1, true)`},
+        },
+        {
+            "KeepAliveInterval", lost, "sleep 1\n",
+            func(r Runner) error { return r.Run() },
+            []string{`RunStarted("127.0.0.1", "test")`, `ConnectionLost("127.0.0.1", "test")`, `RunFinished("127.0.0.1", "test", -1, true)`},
+        },
+    }
+    for _, test := range tests {
+        r, err := New(test.connection, newTestScript(t, "sh", test.code), nil)
+        if err != nil {
+            t.Fatalf("%s: New() failed: %v", test.name, err)
+        }
+        _ = test.run(r)
+        r.Close()
+
+        calls := o.reset()
+        if strings.Join(calls, "\n") != strings.Join(test.calls, "\n") {
+            t.Errorf("%s: calls = %q, want %q", test.name, calls, test.calls)
+        }
+    }
+}
+
+func TestObserverAuthFailed(t *testing.T) {
+    o := setTestObserver(t)
+    _, c := newTestServer(t)
+    c.Password = "wrong"
+
+    r, err := New(c, newTestScript(t, "sh", "true\n"), nil)
+    if err == nil {
+        r.Close()
+        t.Fatal("New() succeeded with a wrong password")
+    }
+
+    calls := o.reset()
+    want := []string{fmt.Sprintf("AuthFailed(%q)", c.Host)}
+    if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+        t.Errorf("calls = %q, want %q", calls, want)
+    }
+}
+
+func TestNopObserverDoesntWrap(t *testing.T) {
+    r, err := New(map[string]string{"Type": "local"}, newTestScript(t, "sh", "true\n"), nil)
+    if err != nil {
+        t.Fatalf("New() failed: %v", err)
+    }
+    defer r.Close()
+
+    if _, ok := r.(*observedRunner); ok {
+        t.Errorf("New() returned an *observedRunner without an observer")
+    }
+}
+
+//------------------------------------------------------------------------------
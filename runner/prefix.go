@@ -0,0 +1,80 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package runner
+
+import (
+    "bytes"
+    "io"
+    "sync"
+)
+
+//------------------------------------------------------------------------------
+
+// a prefix writer prefixes every line with a label, f.i. "[host1] ", to keep the output of several hosts readable
+//
+// remark that a partial line is kept until its newline is written, or until the writer is closed
+// remark that every line is written to the underlying writer in a single Write(), so lines from several prefix writers
+// sharing the same underlying writer don't get mixed, as long as that writer is safe for concurrent use
+type prefixWriter struct {
+    mutex   sync.Mutex
+    prefix  []byte
+    writer  io.Writer
+    partial []byte
+}
+
+//------------------------------------------------------------------------------
+
+func PrefixWriter(prefix string, w io.Writer) io.WriteCloser {
+    // remark that Close() writes the last partial line, but doesn't close w
+    return &prefixWriter{prefix: []byte(prefix), writer: w}
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+
+    data := p
+    for len(data) > 0 {
+        i := bytes.IndexByte(data, '\n')
+        if i < 0 {
+            w.partial = append(w.partial, data...)
+            break
+        }
+
+        err := w.writeLine(data[:i+1])
+        if err != nil {
+            return 0, err
+        }
+        data = data[i+1:]
+    }
+
+    return len(p), nil
+}
+
+func (w *prefixWriter) Close() error {
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+
+    if len(w.partial) == 0 {
+        return nil
+    }
+
+    return w.writeLine([]byte("\n"))
+}
+
+func (w *prefixWriter) writeLine(end []byte) error {
+    line := make([]byte, 0, len(w.prefix) + len(w.partial) + len(end))
+    line = append(line, w.prefix...)
+    line = append(line, w.partial...)
+    line = append(line, end...)
+    w.partial = w.partial[:0]
+
+    _, err := w.writer.Write(line)
+    return err
+}
+
+//------------------------------------------------------------------------------
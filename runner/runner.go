@@ -7,11 +7,20 @@
 package runner
 
 import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
     "fmt"
     "io"
+    "net"
     "reflect"
     "strings"
+    "sync"
+    "syscall"
+    "time"
 
+    "github.com/stefaanc/golang-exec/internal/output"
     "github.com/stefaanc/golang-exec/script"
     "github.com/stefaanc/golang-exec/runner/local"
     "github.com/stefaanc/golang-exec/runner/ssh"
@@ -28,22 +37,105 @@ type Error interface {
 }
 
 type Runner interface {
+    SetPreRunHook(func(command string) error)   // the command is aborted when the hook returns an error
+    SetStdinReaders(...io.Reader) error   // replaces the rendered script, errors when combined with script arguments
+    SetStdinFile(string) error   // replaces the rendered script, errors when combined with script arguments
     SetStdoutWriter(io.Writer)
     SetStderrWriter(io.Writer)
+    SetStdoutWriteCloser(io.WriteCloser)   // closed when the script completes
+    SetStderrWriteCloser(io.WriteCloser)   // closed when the script completes
+    SetStdoutTee(io.Writer)   // writes to the writer and captures, see CapturedStdout()
+    SetStderrTee(io.Writer)   // writes to the writer and captures, see CapturedStderr()
+    SetCaptureOutput(bool)   // captures the output, see CapturedStdout() and CapturedStderr()
+    SetStderrInError(bool)   // appends the first lines of stderr to the error when the script fails
+    SetOutputFilter(func(line string) string, bool)   // filters every line of the captured output, and of the output to the writers when true, f.i. to redact secrets
+    SetStdoutCallback(func([]byte))   // called with output chunks as they arrive, until Run() or Wait() returns
+    SetStderrCallback(func([]byte))   // called with output chunks as they arrive, until Run() or Wait() returns
     StdoutPipe() (io.Reader, error)   // use in combination with Start() & Wait(), don't use in combination with Run()
     StderrPipe() (io.Reader, error)   // use in combination with Start() & Wait(), don't use in combination with Run()
+    StdoutReadCloser() (io.ReadCloser, error)   // like StdoutPipe(), closing the reader closes the runner
+    StderrReadCloser() (io.ReadCloser, error)   // like StderrPipe(), closing the reader closes the runner
+    StdoutChan() (<-chan []byte, error)         // like StdoutPipe(), the chunks are sent on a channel that is closed when the command finishes
+    StderrChan() (<-chan []byte, error)         // like StderrPipe(), the chunks are sent on a channel that is closed when the command finishes
 
     Run() error
+    RunRaw() (int, error)   // returns the exit code, a non-zero exit code is not an error
     Start() error
+    Stream(stdout io.Writer, stderr io.Writer) error   // starts the script, copying stdout and stderr concurrently until Wait() returns, use instead of StdoutPipe() & StderrPipe()
+    StartWithDeadline(time.Time) error   // kills the script when Wait() didn't return before the deadline
+    RunContextTimeout(context.Context, time.Duration) error   // kills the script when the context is done or the timeout expires
     Wait() error
     Close() error
+    CloseOnContext(context.Context)   // kills the script when the context is done, until Run() or Wait() returns or the runner is closed
 
+    CapturedStdout() string   // output captured when using SetCaptureOutput() or SetStdoutTee()
+    CapturedStderr() string   // output captured when using SetCaptureOutput() or SetStderrTee()
+    DetectPlatform() (string, string, error)   // returns the os and architecture, normalized to GOOS and GOARCH values where possible
+    Command() string   // the command that is executed, including any wrapping
     ExitCode() int   // -1 when runner error without completing script
+    Duration() time.Duration   // the time the command ran, excluding connecting to the host
+    Status() (int, string, bool)   // exit code, signal name when killed by a signal, false when there is no exit code
+    StderrMerged() bool   // true when stderr is merged into stdout, f.i. when using a pty
 }
 
+type Step struct {
+    Script     *script.Script
+    Arguments  interface{}
+    TrimOutput bool        // trims leading and trailing whitespace from the captured stdout and stderr in the result
+    Stdout     io.Writer   // optional, the output is also streamed to this writer while the step runs
+    Stderr     io.Writer   // optional, the output is also streamed to this writer while the step runs
+    PrefixHost bool        // prefixes every streamed line with the host, f.i. "[host1] ", see PrefixWriter()
+    OutputFilter func(line string) string   // optional, every line of the output is passed through the filter before it is captured or streamed, f.i. to redact secrets
+
+    // the step is run again when it exits with one of the 'RetryExitCodes', f.i. 75 (EX_TEMPFAIL), up to 'MaxAttempts' times
+    // the step is also run again when it fails with a retryable error, f.i. a refused connection, see IsRetryable()
+    // remark that the script must be idempotent, since a failed attempt may have made part of its changes
+    RetryExitCodes []int
+    MaxAttempts    int             // including the first attempt, defaults to 1
+    RetryBackoff   time.Duration   // the delay before the second attempt, doubled for every next attempt
+}
+
+type Result struct {
+    Host     string   // empty for a local runner
+    Script   string   // the name of the script
+    ExitCode int   // -1 when runner error without completing script
+    Stdout   string
+    Stderr   string
+    Err      error
+    Duration time.Duration   // of the last attempt
+    Attempts int   // the number of attempts to run the step, 0 when it was skipped
+}
+
+type TransactionStep struct {
+    Forward  Step
+    Rollback Step   // optional, no rollback when 'Rollback.Script' is nil
+}
+
+type RollbackError struct {
+    Err          error     // the error of the failed forward step
+    RollbackErrs []error   // the errors of the failed rollback steps
+}
+
+var ErrStepSkipped = errors.New("[golang-exec/runner] step skipped because an earlier step failed")
+
+//------------------------------------------------------------------------------
+
+func (e *RollbackError) Error() string {
+    msg := e.Err.Error()
+    for _, err := range e.RollbackErrs {
+        msg += fmt.Sprintf("[golang-exec/runner/RunAllWithRollback()] rollback failed: %s", err.Error())
+    }
+    return msg
+}
+
+func (e *RollbackError) Unwrap() error { return e.Err }
+
 //------------------------------------------------------------------------------
 
 func Run(connection interface {}, s *script.Script, arguments interface{}, stdout, stderr io.Writer) error {
+    if s == nil {
+        return fmt.Errorf("[golang-exec/runner/Run()] missing 'script' parameter\n")
+    }
     if s.Error != nil {
         return s.Error
     }
@@ -70,11 +162,622 @@ func Run(connection interface {}, s *script.Script, arguments interface{}, stdou
     return nil
 }
 
+func RunJSON[T any](connection interface {}, s *script.Script, arguments interface{}) (T, error) {
+    // runs the script and decodes its stdout as json into a value of type T
+    // remark that stderr is attached to the error when the script fails
+    var result T
+    var stdout bytes.Buffer
+    var stderr bytes.Buffer
+
+    err := Run(connection, s, arguments, &stdout, &stderr)
+    if err != nil {
+        return result, fmt.Errorf("[golang-exec/runner/RunJSON()] runner failed: %#w\nstderr: \n%s\n", err, stderr.String())
+    }
+
+    err = json.Unmarshal(stdout.Bytes(), &result)
+    if err != nil {
+        return result, fmt.Errorf("[golang-exec/runner/RunJSON()] cannot decode stdout as json: %#w\n", err)
+    }
+
+    return result, nil
+}
+
+func RunLines(connection interface {}, s *script.Script, arguments interface{}) ([]string, error) {
+    // runs the script and returns the lines of its stdout, without the line endings and without empty lines
+    // remark that CRLF line endings are handled like LF line endings, f.i. for "cmd" or "powershell" scripts
+    // remark that stderr is attached to the error when the script fails
+    var stdout bytes.Buffer
+    var stderr bytes.Buffer
+
+    err := Run(connection, s, arguments, &stdout, &stderr)
+    if err != nil {
+        return nil, fmt.Errorf("[golang-exec/runner/RunLines()] runner failed: %#w\nstderr: \n%s\n", err, stderr.String())
+    }
+
+    lines := []string{}
+    for _, line := range strings.Split(stdout.String(), "\n") {
+        line = strings.TrimRight(line, "\r")
+        if len(strings.TrimSpace(line)) == 0 {
+            continue
+        }
+        lines = append(lines, line)
+    }
+
+    return lines, nil
+}
+
+func RunFromRegistry(connection interface {}, registry *script.Registry, name string, arguments interface{}, stdout, stderr io.Writer) error {
+    s, ok := registry.Get(name)
+    if !ok {
+        return fmt.Errorf("[golang-exec/runner/RunFromRegistry()] script %q not found in registry\n", name)
+    }
+
+    return Run(connection, s, arguments, stdout, stderr)
+}
+
+func RunCapture(connection interface {}, s *script.Script, arguments interface{}) Result {
+    // runs the script and captures its output, the error is returned in the result
+    return RunCaptureStep(connection, Step{Script: s, Arguments: arguments})
+}
+
+func RunCaptureStep(connection interface {}, step Step) Result {
+    // same as RunCapture(), using the options of the step
+    newRunner := func(s *script.Script, arguments interface{}) (Runner, error) {
+        return New(connection, s, arguments)
+    }
+
+    return runStep(connection, newRunner, step)
+}
+
+func RunFanout(connections []interface {}, s *script.Script, arguments interface{}) []Result {
+    // runs the script on all connections in parallel, results are ordered by input
+    return RunFanoutStep(connections, Step{Script: s, Arguments: arguments})
+}
+
+func RunFanoutStep(connections []interface {}, step Step) []Result {
+    // same as RunFanout(), using the options of the step
+    // remark that every connection can have its own 'ProxyJump', connections with the same 'ProxyJump' share the
+    // client to their jump host, see ssh.JumpPool
+    results := make([]Result, len(connections))
+
+    pool := ssh.NewJumpPool()
+    defer pool.Close()
+
+    var wg sync.WaitGroup
+    for i, connection := range connections {
+        wg.Add(1)
+        go func(i int, connection interface {}) {
+            defer wg.Done()
+            if connectionType(connection) != "ssh" {
+                results[i] = RunCaptureStep(connection, step)
+                return
+            }
+
+            newRunner := func(s *script.Script, arguments interface{}) (Runner, error) {
+                r, err := pool.New(connection, s, arguments)
+                if err != nil {
+                    observeNewError(connection, err)
+                    auditNewError(connection, s, err)
+                    return nil, err
+                }
+                return audit(connection, observe(connection, r, s.Name), s), nil
+            }
+            results[i] = runStep(connection, newRunner, step)
+        }(i, connection)
+    }
+    wg.Wait()
+
+    return results
+}
+
 func New(connection interface {}, s *script.Script, arguments interface{}) (Runner, error) {
+    if s == nil {
+        return nil, fmt.Errorf("[golang-exec/runner/New()] missing 'script' parameter\n")
+    }
     if s.Error != nil {
+        auditNewError(connection, s, s.Error)
         return nil, s.Error
     }
 
+    switch connectionType(connection) {
+    case "local":
+        r, err := local.New(connection, s, arguments)
+        if err != nil {
+            auditNewError(connection, s, err)
+            return nil, err
+        }
+        return audit(connection, observe(connection, r, s.Name), s), nil
+    case "ssh":
+        r, err := ssh.New(connection, s, arguments)
+        if err != nil {
+            observeNewError(connection, err)
+            auditNewError(connection, s, err)
+            return nil, err
+        }
+        return audit(connection, observe(connection, r, s.Name), s), nil
+    default:
+        err := fmt.Errorf("[golang-exec/runner/New()] invalid 'Type' in 'connection' parameter")
+        auditNewError(connection, s, err)
+        return nil, err
+    }
+}
+
+func RunAll(connection interface {}, steps []Step, maxChannels int) ([]Result, error) {
+    // runs the steps over a single connection, using up to maxChannels sessions in parallel
+    // - results are ordered by input, independent of the order in which steps complete
+    // - no new steps are started after a step fails, these get 'ErrStepSkipped'
+    // - the returned error is the error of the first failed step
+    //
+    // remark that maxChannels should not exceed the server's 'MaxSessions' (10 by default for OpenSSH)
+    if maxChannels < 1 {
+        maxChannels = 1
+    }
+
+    err := checkSteps("RunAll", steps)
+    if err != nil {
+        return nil, err
+    }
+
+    var first *script.Script
+    if len(steps) > 0 {
+        first = steps[0].Script
+    }
+
+    newRunner, closeClient, err := newStepRunner(connection, first)
+    if err != nil {
+        return nil, err
+    }
+    defer closeClient()
+
+    results := make([]Result, len(steps))
+    for i := range results {
+        results[i] = skippedResult(connection, steps[i])
+    }
+
+    var mutex sync.Mutex
+    var failed bool
+    var wg sync.WaitGroup
+    channels := make(chan struct{}, maxChannels)
+    for i, step := range steps {
+        channels <- struct{}{}
+
+        mutex.Lock()
+        stop := failed
+        mutex.Unlock()
+        if stop {
+            <-channels
+            break
+        }
+
+        wg.Add(1)
+        go func(i int, step Step) {
+            defer wg.Done()
+            defer func() { <-channels }()
+
+            result := runStep(connection, newRunner, step)
+
+            mutex.Lock()
+            results[i] = result
+            if result.Err != nil {
+                failed = true
+            }
+            mutex.Unlock()
+        }(i, step)
+    }
+    wg.Wait()
+
+    for _, result := range results {
+        if result.Err != nil && result.Err != ErrStepSkipped {
+            return results, result.Err
+        }
+    }
+
+    return results, nil
+}
+
+func RunRepeated(connection interface {}, s *script.Script, argumentsSets []interface{}, stopOnError bool) []Result {
+    // runs the script once for every set of arguments, sequentially over a single connection, f.i. to create a list of users
+    // - every run gets its own session, results are ordered by input
+    // - when stopOnError is true, no new runs are started after a run fails, these get 'ErrStepSkipped'
+    // - when the connection fails, every result gets the error of the connection
+    results := make([]Result, len(argumentsSets))
+
+    if s == nil {
+        err := fmt.Errorf("[golang-exec/runner/RunRepeated()] missing 'script' parameter\n")
+        for i := range results {
+            results[i] = Result{Host: connectionHost(connection), ExitCode: -1, Err: err}
+        }
+        return results
+    }
+
+    newRunner, closeClient, err := newStepRunner(connection, s)
+    if err != nil {
+        for i := range results {
+            results[i] = Result{Host: connectionHost(connection), Script: s.Name, ExitCode: -1, Err: err}
+        }
+        return results
+    }
+    defer closeClient()
+
+    failed := false
+    for i, arguments := range argumentsSets {
+        step := Step{Script: s, Arguments: arguments}
+        if failed {
+            results[i] = skippedResult(connection, step)
+            continue
+        }
+
+        results[i] = runStep(connection, newRunner, step)
+        if results[i].Err != nil && stopOnError {
+            failed = true
+        }
+    }
+
+    return results
+}
+
+func RunAllInShell(connection interface {}, steps []Step) ([]Result, error) {
+    // runs the steps sequentially in a single persistent shell, so the working directory and the environment carry over between steps
+    // - results are ordered by input, no new steps are started after a step fails, these get 'ErrStepSkipped'
+    // - the exit code of each step is detected using markers in the output, see (*ssh.Shell).RunScript()
+    // - the returned error is the error of the failed step
+    //
+    // remark that this trades the isolation of the steps for the continuity of their state
+    // - the steps are executed by the shell of the first step, they must not read from stdin or "exit" the shell
+    // - the steps are not retried, 'RetryExitCodes' is ignored
+    // - a failed step doesn't undo its changes to the shell's state
+    // - the output of a step is only complete when the step finished, hence it is filtered and written to the step's
+    //   'Stdout' and 'Stderr' after the step finished, instead of while it runs
+    // remark that this is only supported for ssh connections and posix shells
+    if connectionType(connection) != "ssh" {
+        return nil, fmt.Errorf("[golang-exec/runner/RunAllInShell()] persistent shell is only supported for ssh connections\n")
+    }
+
+    err := checkSteps("RunAllInShell", steps)
+    if err != nil {
+        return nil, err
+    }
+
+    results := make([]Result, len(steps))
+    for i := range results {
+        results[i] = skippedResult(connection, steps[i])
+    }
+    if len(steps) == 0 {
+        return results, nil
+    }
+
+    shell := steps[0].Script.Shell
+    switch shell {
+    case "cmd", "powershell", "fish":
+        return nil, fmt.Errorf("[golang-exec/runner/RunAllInShell()] persistent shell is not supported for shell %q\n", shell)
+    }
+
+    sh, err := ssh.NewShell(connection)
+    if err != nil {
+        observeNewError(connection, err)
+        auditNewError(connection, steps[0].Script, err)
+        return nil, err
+    }
+    defer sh.Close()
+
+    if shell != "raw" && shell != "exec" {
+        err = sh.ExecShell(shell)
+        if err != nil {
+            auditNewError(connection, steps[0].Script, err)
+            return nil, err
+        }
+    }
+
+    for i, step := range steps {
+        start := time.Now()
+        stdout, stderr, exitCode, err := sh.RunScript(step.Script, step.Arguments)
+        duration := time.Since(start)
+        if step.OutputFilter != nil {
+            stdout = filterLines(stdout, step.OutputFilter)
+            stderr = filterLines(stderr, step.OutputFilter)
+        }
+        if step.Stdout != nil {
+            writer, flush := streamWriter(new(bytes.Buffer), step.Stdout, connection, step.PrefixHost)
+            _, _ = io.WriteString(writer, stdout)
+            flush()
+        }
+        if step.Stderr != nil {
+            writer, flush := streamWriter(new(bytes.Buffer), step.Stderr, connection, step.PrefixHost)
+            _, _ = io.WriteString(writer, stderr)
+            flush()
+        }
+
+        results[i] = Result{
+            Host:     connectionHost(connection),
+            Script:   step.Script.Name,
+            ExitCode: exitCode,
+            Stdout:   stdout,
+            Stderr:   stderr,
+            Err:      err,
+            Duration: duration,
+            Attempts: 1,
+        }
+        if step.TrimOutput {
+            results[i].Stdout = strings.TrimSpace(results[i].Stdout)
+            results[i].Stderr = strings.TrimSpace(results[i].Stderr)
+        }
+        if err != nil {
+            return results, err
+        }
+    }
+
+    return results, nil
+}
+
+func RunAllWithRollback(connection interface {}, steps []TransactionStep) ([]Result, []Result, error) {
+    // runs the forward steps sequentially over a single connection
+    // when a forward step fails, the rollback steps of the completed forward steps are run in reverse order
+    // - the first returned results are the results of the forward steps, skipped steps get 'ErrStepSkipped'
+    // - the second returned results are the results of the rollback steps, in the order they were run
+    // - the returned error is a '*RollbackError' when rollback steps failed, and the error of the failed forward step otherwise
+    for i, step := range steps {
+        if step.Forward.Script == nil {
+            return nil, nil, fmt.Errorf("[golang-exec/runner/RunAllWithRollback()] step %d has no 'Forward.Script'\n", i)
+        }
+    }
+
+    var first *script.Script
+    if len(steps) > 0 {
+        first = steps[0].Forward.Script
+    }
+
+    newRunner, closeClient, err := newStepRunner(connection, first)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer closeClient()
+
+    results := make([]Result, len(steps))
+    for i := range results {
+        results[i] = skippedResult(connection, steps[i].Forward)
+    }
+
+    failed := -1
+    for i, step := range steps {
+        results[i] = runStep(connection, newRunner, step.Forward)
+        if results[i].Err != nil {
+            failed = i
+            break
+        }
+    }
+    if failed < 0 {
+        return results, nil, nil
+    }
+
+    var rollbackResults []Result
+    var rollbackErrs []error
+    for i := failed - 1; i >= 0; i-- {
+        if steps[i].Rollback.Script == nil {
+            continue
+        }
+
+        result := runStep(connection, newRunner, steps[i].Rollback)
+        rollbackResults = append(rollbackResults, result)
+        if result.Err != nil {
+            rollbackErrs = append(rollbackErrs, result.Err)
+        }
+    }
+
+    if len(rollbackErrs) > 0 {
+        return results, rollbackResults, &RollbackError{
+            Err:          results[failed].Err,
+            RollbackErrs: rollbackErrs,
+        }
+    }
+
+    return results, rollbackResults, results[failed].Err
+}
+
+func newStepRunner(connection interface {}, first *script.Script) (func(*script.Script, interface{}) (Runner, error), func(), error) {
+    // returns a function to create runners that share a single client, and a function to close that client
+    // remark that when the client cannot be created, the error is audited for the first script, as if New() failed for it
+    switch connectionType(connection) {
+    case "local":
+        newRunner := func(s *script.Script, arguments interface{}) (Runner, error) {
+            r, err := local.New(connection, s, arguments)
+            if err != nil {
+                auditNewError(connection, s, err)
+                return nil, err
+            }
+            return audit(connection, observe(connection, r, s.Name), s), nil
+        }
+        return newRunner, func() {}, nil
+    case "ssh":
+        client, err := ssh.NewClient(connection)
+        if err != nil {
+            observeNewError(connection, err)
+            auditNewError(connection, first, err)
+            return nil, nil, err
+        }
+
+        newRunner := func(s *script.Script, arguments interface{}) (Runner, error) {
+            r, err := ssh.NewWithClient(client, connection, s, arguments)
+            if err != nil {
+                auditNewError(connection, s, err)
+                return nil, err
+            }
+            return audit(connection, observe(connection, r, s.Name), s), nil
+        }
+        return newRunner, func() { client.Close() }, nil
+    default:
+        err := fmt.Errorf("[golang-exec/runner] invalid 'Type' in 'connection' parameter")
+        auditNewError(connection, first, err)
+        return nil, nil, err
+    }
+}
+
+func runStep(connection interface {}, newRunner func(*script.Script, interface{}) (Runner, error), step Step) Result {
+    // runs the step, and runs it again when it exits with one of the 'RetryExitCodes' or fails with a retryable error
+    backoff := step.RetryBackoff
+    for attempt := 1; ; attempt++ {
+        result := runAttempt(connection, newRunner, step)
+        retry := IsRetryable(result.Err) || (result.ExitCode >= 0 && containsExitCode(step.RetryExitCodes, result.ExitCode))
+        if result.Err == nil || attempt >= step.MaxAttempts || !retry {
+            result.Attempts = attempt
+            return result
+        }
+
+        time.Sleep(backoff)
+        backoff *= 2
+    }
+}
+
+func IsRetryable(err error) bool {
+    // returns true when the error is transient, so running the script again may succeed:
+    // - the host refused or reset the connection, f.i. because its ssh daemon is restarting
+    // - dialing the host or the handshake timed out
+    // - the connection was lost while the script was running, see 'KeepAliveInterval' of an ssh connection
+    // returns false for authentication failures, for scripts that exited with an exit code, for scripts that were killed
+    // after a deadline, a timeout or a cancelled context, and for other errors
+    // remark that a script that was interrupted by a lost connection may have made part of its changes
+    if err == nil {
+        return false
+    }
+
+    if errors.Is(err, ssh.ErrAuthFailed) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+        return false
+    }
+
+    var runnerErr Error
+    if errors.As(err, &runnerErr) && runnerErr.ExitCode() >= 0 {
+        return false
+    }
+
+    if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, ssh.ErrConnectionLost) {
+        return true
+    }
+
+    var netErr net.Error
+    if errors.As(err, &netErr) && netErr.Timeout() {
+        return true
+    }
+
+    return false
+}
+
+func containsExitCode(exitCodes []int, exitCode int) bool {
+    for _, code := range exitCodes {
+        if code == exitCode {
+            return true
+        }
+    }
+    return false
+}
+
+func runAttempt(connection interface {}, newRunner func(*script.Script, interface{}) (Runner, error), step Step) Result {
+    result := Result{
+        Host:     connectionHost(connection),
+        ExitCode: -1,
+    }
+
+    if step.Script == nil {
+        result.Err = fmt.Errorf("[golang-exec/runner] step has no 'Script'\n")
+        return result
+    }
+    result.Script = step.Script.Name
+
+    if step.Script.Error != nil {
+        result.Err = step.Script.Error
+        return result
+    }
+
+    r, err := newRunner(step.Script, step.Arguments)
+    if err != nil {
+        result.Err = err
+        return result
+    }
+    defer r.Close()
+
+    var stdout bytes.Buffer
+    var stderr bytes.Buffer
+    stdoutWriter, flushStdout := streamWriter(&stdout, step.Stdout, connection, step.PrefixHost)
+    stderrWriter, flushStderr := streamWriter(&stderr, step.Stderr, connection, step.PrefixHost)
+    r.SetStdoutWriter(stdoutWriter)
+    r.SetStderrWriter(stderrWriter)
+    if step.OutputFilter != nil {
+        r.SetOutputFilter(step.OutputFilter, true)
+    }
+
+    start := time.Now()
+    err = r.Run()
+    result.Duration = time.Since(start)
+    flushStdout()
+    flushStderr()
+
+    result.ExitCode = r.ExitCode()
+    result.Stdout = stdout.String()
+    result.Stderr = stderr.String()
+    result.Err = err
+
+    if step.TrimOutput {
+        // remark that only the strings in the result are trimmed
+        result.Stdout = strings.TrimSpace(result.Stdout)
+        result.Stderr = strings.TrimSpace(result.Stderr)
+    }
+
+    return result
+}
+
+func streamWriter(buffer *bytes.Buffer, stream io.Writer, connection interface {}, prefixHost bool) (io.Writer, func()) {
+    // returns the writer for the output of a step, that captures the output in buffer and also streams it when requested,
+    // and a function to write the last partial line of the stream when the step finished
+    if stream == nil {
+        return buffer, func() {}
+    }
+    if !prefixHost {
+        return io.MultiWriter(buffer, stream), func() {}
+    }
+
+    host := connectionHost(connection)
+    if len(host) == 0 {
+        host = "local"
+    }
+    prefixed := PrefixWriter("[" + host + "] ", stream)
+
+    return io.MultiWriter(buffer, prefixed), func() { _ = prefixed.Close() }
+}
+
+func filterLines(text string, filter func(line string) string) string {
+    // passes every line of the output through the filter, like the output filter of the runners
+    var b strings.Builder
+    w := &output.FilterWriter{Writer: &b, Filter: filter}
+    _, _ = io.WriteString(w, text)
+    _ = w.Flush()
+
+    return b.String()
+}
+
+func checkSteps(caller string, steps []Step) error {
+    // returns an error for the first step without a script, so no step is run
+    for i, step := range steps {
+        if step.Script == nil {
+            return fmt.Errorf("[golang-exec/runner/%s()] step %d has no 'Script'\n", caller, i)
+        }
+    }
+
+    return nil
+}
+
+func skippedResult(connection interface {}, step Step) Result {
+    var name string
+    if step.Script != nil {
+        name = step.Script.Name
+    }
+
+    return Result{
+        Host:     connectionHost(connection),
+        Script:   name,
+        ExitCode: -1,
+        Err:      ErrStepSkipped,
+    }
+}
+
+func connectionType(connection interface {}) string {
     var cType string
     v := reflect.Indirect(reflect.ValueOf(connection))
     if v.Kind() == reflect.Struct {
@@ -89,14 +792,41 @@ func New(connection interface {}, s *script.Script, arguments interface{}) (Runn
         }
     }
 
-    switch cType {
-    case "local":
-        return local.New(connection, s, arguments)
-    case "ssh":
-        return ssh.New(connection, s, arguments)
-    default:
-        return nil, fmt.Errorf("[golang-exec/runner/New()] invalid 'Type' in 'connection' parameter")
+    return cType
+}
+
+func connectionHost(connection interface {}) string {
+    if connectionType(connection) != "ssh" {
+        return ""
+    }
+    return connectionField(connection, "Host")
+}
+
+func connectionUser(connection interface {}) string {
+    if connectionType(connection) != "ssh" {
+        return ""
     }
+    return connectionField(connection, "User")
+}
+
+func connectionField(connection interface {}, name string) string {
+    v := reflect.Indirect(reflect.ValueOf(connection))
+    if v.Kind() == reflect.Struct {
+        f := v.FieldByName(name)
+        if f.IsValid() && f.Kind() == reflect.String {
+            return f.String()
+        }
+        return ""
+    }
+
+    iter := v.MapRange()
+    for iter.Next() {
+        if iter.Key().String() == name {
+            return iter.Value().String()
+        }
+    }
+
+    return ""
 }
 
 //------------------------------------------------------------------------------
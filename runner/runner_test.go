@@ -0,0 +1,212 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package runner
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "strings"
+    "syscall"
+    "testing"
+    "time"
+
+    gossh "golang.org/x/crypto/ssh"
+
+    "github.com/stefaanc/golang-exec/internal/sshtest"
+    "github.com/stefaanc/golang-exec/runner/ssh"
+    "github.com/stefaanc/golang-exec/script"
+)
+
+//------------------------------------------------------------------------------
+
+func newTestServer(t *testing.T) (*sshtest.Server, ssh.Connection) {
+    // returns an in-process ssh server, and a connection to it that pins its host key
+    // remark that the server is closed after the runners, these must be closed by the test itself
+    t.Helper()
+
+    srv, err := sshtest.NewServer("test", "secret")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { srv.Close() })
+
+    c := ssh.Connection{
+        Type:                "ssh",
+        Host:                srv.Host,
+        Port:                srv.Port,
+        User:                srv.User,
+        Password:            srv.Password,
+        ExpectedFingerprint: gossh.FingerprintSHA256(srv.HostKey()),
+    }
+
+    return srv, c
+}
+
+func newTestScript(t *testing.T, shell string, code string) *script.Script {
+    t.Helper()
+
+    s, err := script.NewFromString("test", shell, code)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return s
+}
+
+//------------------------------------------------------------------------------
+
+func TestIsRetryable(t *testing.T) {
+    tests := []struct {
+        name      string
+        err       error
+        retryable bool
+    }{
+        {"nil", nil, false},
+        {"connection refused", fmt.Errorf("cannot dial host: %w", syscall.ECONNREFUSED), true},
+        {"connection reset", fmt.Errorf("cannot dial host: %w", syscall.ECONNRESET), true},
+        {"connection lost", fmt.Errorf("runner failed: %w", ssh.ErrConnectionLost), true},
+        {"authentication failed", fmt.Errorf("cannot authenticate with host: %w", ssh.ErrAuthFailed), false},
+        {"deadline exceeded", fmt.Errorf("runner killed: %w", context.DeadlineExceeded), false},
+        {"cancelled", fmt.Errorf("runner killed: %w", context.Canceled), false},
+        {"other", fmt.Errorf("invalid 'Type' in 'connection' parameter"), false},
+    }
+    for _, test := range tests {
+        if retryable := IsRetryable(test.err); retryable != test.retryable {
+            t.Errorf("%s: IsRetryable() = %v, want %v", test.name, retryable, test.retryable)
+        }
+    }
+}
+
+func TestIsRetryableDuringAuthentication(t *testing.T) {
+    // a timeout while the host checks the credentials is transient, a rejected password is not
+    srv, c := newTestServer(t)
+    srv.AuthDelay = time.Second
+    c.HandshakeTimeout = 100 * time.Millisecond
+
+    r, err := New(c, newTestScript(t, "sh", "true\n"), nil)
+    if err == nil {
+        r.Close()
+        t.Fatal("New() succeeded, want a handshake timeout")
+    }
+    if !IsRetryable(err) {
+        t.Errorf("IsRetryable(%v) = false, want true", err)
+    }
+
+    _, c = newTestServer(t)
+    c.Password = "wrong"
+
+    r, err = New(c, newTestScript(t, "sh", "true\n"), nil)
+    if err == nil {
+        r.Close()
+        t.Fatal("New() succeeded with a wrong password")
+    }
+    if IsRetryable(err) {
+        t.Errorf("IsRetryable(%v) = true, want false", err)
+    }
+}
+
+func TestManagerUntracks(t *testing.T) {
+    m := NewManager()
+
+    run := map[string]func(Runner) error{
+        "Run":    func(r Runner) error { return r.Run() },
+        "RunRaw": func(r Runner) error { _, err := r.RunRaw(); return err },
+        "Close":  func(r Runner) error { return r.Close() },
+    }
+    for name, f := range run {
+        r, err := New(map[string]string{"Type": "local"}, newTestScript(t, "sh", "true\n"), nil)
+        if err != nil {
+            t.Fatalf("New() failed: %v", err)
+        }
+
+        tracked := m.Track(r)
+        err = f(tracked)
+        count := m.Count()
+        tracked.Close()
+        if err != nil {
+            t.Errorf("%s() failed: %v", name, err)
+        }
+        if count != 0 {
+            t.Errorf("%s(): Count() = %d, want 0", name, count)
+        }
+    }
+}
+
+func TestRunAllInShellOutput(t *testing.T) {
+    // the output filter, the streams and the host prefix of the steps apply to a persistent shell too
+    _, c := newTestServer(t)
+
+    redact := func(line string) string { return strings.ReplaceAll(line, "secret", "***") }
+    var stdout, stderr bytes.Buffer
+    steps := []Step{
+        {
+            Script:       newTestScript(t, "sh", "echo password=secret\necho error=secret >&2\n"),
+            OutputFilter: redact,
+            Stdout:       &stdout,
+            Stderr:       &stderr,
+            PrefixHost:   true,
+        },
+        {
+            Script: newTestScript(t, "sh", "echo secret\n"),
+        },
+    }
+
+    results, err := RunAllInShell(c, steps)
+    if err != nil {
+        t.Fatalf("RunAllInShell() failed: %v", err)
+    }
+    if results[0].Stdout != "password=***\n" || results[0].Stderr != "error=***\n" {
+        t.Errorf("step 0: output %q, %q, want it filtered", results[0].Stdout, results[0].Stderr)
+    }
+    prefix := "[" + c.Host + "] "
+    if stdout.String() != prefix+"password=***\n" || stderr.String() != prefix+"error=***\n" {
+        t.Errorf("step 0: streamed %q, %q, want it filtered and prefixed", stdout.String(), stderr.String())
+    }
+    if results[1].Stdout != "secret\n" {
+        t.Errorf("step 1: output %q, want it unfiltered", results[1].Stdout)
+    }
+}
+
+func TestNilScript(t *testing.T) {
+    // a step without a script is an error instead of a panic, and no step is run
+    _, c := newTestServer(t)
+    local := map[string]string{"Type": "local"}
+
+    var ran bytes.Buffer
+    first := Step{Script: newTestScript(t, "sh", "echo ran\n"), Stdout: &ran}
+
+    _, err := RunAll(local, []Step{first, {}}, 1)
+    if err == nil {
+        t.Error("RunAll() succeeded, want an error")
+    }
+    _, err = RunAllInShell(c, []Step{first, {}})
+    if err == nil {
+        t.Error("RunAllInShell() succeeded, want an error")
+    }
+    _, _, err = RunAllWithRollback(local, []TransactionStep{{Forward: first}, {}})
+    if err == nil {
+        t.Error("RunAllWithRollback() succeeded, want an error")
+    }
+    if ran.Len() > 0 {
+        t.Errorf("output = %q, want no step to run", ran.String())
+    }
+
+    for _, result := range RunRepeated(local, nil, []interface{}{nil, nil}, false) {
+        if result.Err == nil {
+            t.Error("RunRepeated() succeeded, want an error")
+        }
+    }
+    if result := RunCapture(local, nil, nil); result.Err == nil {
+        t.Error("RunCapture() succeeded, want an error")
+    }
+    if _, err := New(local, nil, nil); err == nil {
+        t.Error("New() succeeded, want an error")
+    }
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,31 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package runnertest
+
+import (
+	"testing"
+
+	"github.com/stefaanc/golang-exec/runner"
+	"github.com/stefaanc/golang-exec/script"
+)
+
+//------------------------------------------------------------------------------
+
+func MustRun(t testing.TB, connection interface{}, s *script.Script, arguments interface{}, wantExit int) runner.Result {
+	// runs the script and fails the test when the exit code differs from wantExit, reporting the captured output
+	// remark that a runner error without an exit code, f.i. failing to connect, is reported as exit code -1
+	t.Helper()
+
+	result := runner.RunCapture(connection, s, arguments)
+	if result.ExitCode != wantExit {
+		t.Fatalf("[golang-exec/runner/runnertest/MustRun()] script %q exited with code %d, want %d\nerror: %v\nstdout: \n%s\nstderr: \n%s\n", result.Script, result.ExitCode, wantExit, result.Err, result.Stdout, result.Stderr)
+	}
+
+	return result
+}
+
+//------------------------------------------------------------------------------
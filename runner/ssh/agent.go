@@ -0,0 +1,70 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+//------------------------------------------------------------------------------
+
+func agentAuthMethod(c *Connection) (ssh.AuthMethod, func(), error) {
+	// returns the auth method offering the keys of the ssh agent, and a function to disconnect from the agent
+	// when an 'IdentityFingerprint' is set, only the matching key is offered - a host with a low "MaxAuthTries"
+	// disconnects a client that offers too many keys, before the right key is tried
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if len(socket) == 0 {
+		return nil, nil, fmt.Errorf("'UseAgent' in 'connection' parameter requires SSH_AUTH_SOCK to be set")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot connect to ssh agent: %w", err)
+	}
+	client := agent.NewClient(conn)
+
+	authMethod := ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		signers, err := client.Signers()
+		if err != nil || len(c.IdentityFingerprint) == 0 {
+			return signers, err
+		}
+
+		return agentIdentity(client, signers, c.IdentityFingerprint)
+	})
+
+	return authMethod, func() { conn.Close() }, nil
+}
+
+func agentIdentity(client agent.Agent, signers []ssh.Signer, identity string) ([]ssh.Signer, error) {
+	// returns the signer of the key with the fingerprint or the comment
+	keys, err := client.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if ssh.FingerprintSHA256(key) != identity && key.Comment != identity {
+			continue
+		}
+
+		for _, signer := range signers {
+			if bytes.Equal(signer.PublicKey().Marshal(), key.Marshal()) {
+				return []ssh.Signer{signer}, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no key in the ssh agent matches 'IdentityFingerprint' %q in 'connection' parameter", identity)
+}
+
+//------------------------------------------------------------------------------
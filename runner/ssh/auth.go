@@ -0,0 +1,130 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+//------------------------------------------------------------------------------
+
+var queryTimeout = 10 * time.Second // timeout for connecting to the host and for the handshake when querying the auth methods
+
+var errQueryAborted = errors.New("query aborted")
+
+// returns the authentication methods that the host accepts for the user, to diagnose authentication failures
+// f.i. ["keyboard-interactive", "password", "publickey"], or ["none"] when the host doesn't require authentication
+//
+// the client first attempts the "none" method, the host rejects that with the list of methods it accepts
+// crypto/ssh doesn't expose that list, so it is found by offering every method with a callback that records
+// the method and aborts before any credentials are sent
+//
+// remark that this is read-only, no credentials are sent and no command is executed
+// remark that only "publickey", "password" and "keyboard-interactive" are detected
+// remark that the host key is not verified, since no credentials are sent
+func QueryAuthMethods(host string, port uint16, user string) ([]string, error) {
+	if port == 0 {
+		port = DefaultPort
+	}
+	address := fmt.Sprintf("%s:%d", host, port)
+
+	// the "password" and "keyboard-interactive" callbacks both abort the authentication, so each needs its own query
+	var mutex sync.Mutex
+	methods := make(map[string]bool)
+	record := func(method string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		methods[method] = true
+	}
+
+	queries := [][]ssh.AuthMethod{
+		{
+			ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				// no signers, the next method is attempted without sending anything
+				record("publickey")
+				return nil, nil
+			}),
+			ssh.PasswordCallback(func() (string, error) {
+				record("password")
+				return "", errQueryAborted
+			}),
+		},
+		{
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				record("keyboard-interactive")
+				return nil, errQueryAborted
+			}),
+		},
+	}
+
+	for _, authMethods := range queries {
+		authenticated, err := queryAuthMethods(address, user, authMethods)
+		if err != nil {
+			return nil, &Error{
+				exitCode: -1,
+				err:      fmt.Errorf("[golang-exec/runner/ssh/QueryAuthMethods()] %#w\n", err),
+			}
+		}
+		if authenticated {
+			return []string{"none"}, nil
+		}
+	}
+
+	result := make([]string, 0, len(methods))
+	for method := range methods {
+		result = append(result, method)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+func queryAuthMethods(address string, user string, authMethods []ssh.AuthMethod) (bool, error) {
+	// returns true when the host accepts the "none" method
+	// remark that a handshake failure after the host key is received is the expected outcome of the query
+	var hostKeyReceived bool
+	config := &ssh.ClientConfig{
+		User: user,
+		Auth: authMethods,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKeyReceived = true
+			return nil
+		},
+	}
+
+	conn, err := net.DialTimeout("tcp", address, queryTimeout)
+	if err != nil {
+		return false, fmt.Errorf("cannot dial host: %w", err)
+	}
+	defer conn.Close()
+
+	// the deadline also bounds the handshake, so a host that accepts the connection but never replies doesn't hang the query
+	err = conn.SetDeadline(time.Now().Add(queryTimeout))
+	if err != nil {
+		return false, fmt.Errorf("cannot set handshake deadline: %w", err)
+	}
+
+	clientConn, _, _, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		if hostKeyReceived {
+			return false, nil
+		}
+		return false, fmt.Errorf("cannot dial host: %w", err)
+	}
+	clientConn.Close()
+
+	return true, nil
+}
+
+//------------------------------------------------------------------------------
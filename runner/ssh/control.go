@@ -0,0 +1,345 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+//------------------------------------------------------------------------------
+
+// a control master keeps a single connection to the host open, and multiplexes the sessions of other processes over it
+// similar to OpenSSH's "ControlMaster" and "ControlPath" options
+//
+// the control master listens on the unix socket in the 'ControlPath' of the connection, and speaks the ssh protocol
+// on that socket - every session channel opened on the socket is forwarded to the host, including its requests
+// runners created with the same 'ControlPath' use the socket when a control master is listening, and dial the host
+// directly otherwise
+//
+// remark that ServeControlMaster() blocks until the connection to the host is lost or the listener is closed,
+// hence a CLI typically starts it in a background process, f.i. by re-executing itself with a hidden flag
+//
+// remark that access to the socket is protected by its file permissions only, the socket is created with mode 0600
+// in a private directory, and then moved to the 'ControlPath' - hence the directory of the 'ControlPath' must be writable
+// remark that the directory of the 'ControlPath' must be private to the user, f.i. "~/.ssh", not a shared directory
+// like "/tmp" - runners don't use a socket that is owned by another user, or that is accessible by other users,
+// see checkControlSocket()
+//
+// remark that the control master announces the host, the port and the user it serves, runners for another host,
+// port or user dial the host directly instead of using the socket, f.i. when a 'ControlPath' is reused
+//
+// remark that unix sockets are not supported on windows, 'ControlPath' is ignored there
+func ServeControlMaster(connection interface{}) error {
+	c := toConnection(connection)
+
+	if runtime.GOOS == "windows" {
+		return &Error{
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/ServeControlMaster()] control master is not supported on windows\n"),
+		}
+	}
+
+	if len(c.ControlPath) == 0 {
+		return &Error{
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/ServeControlMaster()] missing 'ControlPath' in 'connection' parameter\n"),
+		}
+	}
+
+	path, err := c.expandPath(c.ControlPath)
+	if err != nil {
+		return &Error{
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/ServeControlMaster()] cannot find home directory of current user: %#w\n", err),
+		}
+	}
+
+	listener, err := listenControl(path)
+	if err != nil {
+		return &Error{
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/ServeControlMaster()] %#w\n", err),
+		}
+	}
+	defer listener.Close()
+
+	// dial the host directly, not through the socket we are listening on
+	direct := c.Clone()
+	direct.ControlPath = ""
+
+	client, err := newClient(direct)
+	if err != nil {
+		return &Error{
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/ServeControlMaster()] %#w\n", err),
+		}
+	}
+	defer client.Close()
+
+	config, err := newControlConfig(c)
+	if err != nil {
+		return &Error{
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/ServeControlMaster()] %#w\n", err),
+		}
+	}
+
+	// stop listening when the connection to the host is lost
+	go func() {
+		_ = client.Wait()
+		listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			break // listener closed
+		}
+
+		wg.Add(1)
+		go func(conn net.Conn) {
+			defer wg.Done()
+			serveControlConn(client, conn, config)
+		}(conn)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// a listener that removes the socket when it is closed, see listenControl()
+type controlListener struct {
+	net.Listener
+	path string
+}
+
+const controlVersionPrefix = "SSH-2.0-golang-exec-control "
+
+//------------------------------------------------------------------------------
+
+func listenControl(path string) (net.Listener, error) {
+	err := checkControlDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot use directory for %q: %w", path, err)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("control master already listening on %q", path)
+		}
+
+		// a stale socket, left behind by a control master that didn't exit cleanly
+		err = os.Remove(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot remove stale socket %q: %w", path, err)
+		}
+	}
+
+	// the socket is created in a private directory, and only moved into place once its permissions are restricted
+	// otherwise other local users could connect in between, and run commands as this user
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".control-")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create private directory for %q: %w", path, err)
+	}
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, "socket")
+	listener, err := net.Listen("unix", private)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on %q: %w", path, err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	err = os.Chmod(private, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("cannot set permissions on %q: %w", path, err)
+	}
+
+	err = os.Rename(private, path)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("cannot move socket to %q: %w", path, err)
+	}
+
+	return &controlListener{Listener: listener, path: path}, nil
+}
+
+func (l *controlListener) Close() error {
+	err := l.Listener.Close()
+	_ = os.Remove(l.path)
+
+	return err
+}
+
+func controlVersion(c *Connection) string {
+	// returns the version the control master announces, it identifies the host, the port and the user it serves
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s@%s:%d", c.User, c.Host, c.Port)))
+	return fmt.Sprintf("%s%x", controlVersionPrefix, sum[:16])
+}
+
+func newControlConfig(c *Connection) (*ssh.ServerConfig, error) {
+	// the host key is ephemeral, clients don't verify it - the socket's file permissions are the access control
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate host key: %w", err)
+	}
+
+	hostKey, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create host key signer: %w", err)
+	}
+
+	config := &ssh.ServerConfig{
+		NoClientAuth:  true,
+		ServerVersion: controlVersion(c),
+	}
+	config.AddHostKey(hostKey)
+
+	return config, nil
+}
+
+func serveControlConn(client *ssh.Client, conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	var wg sync.WaitGroup
+	for newChannel := range chans {
+		wg.Add(1)
+		go func(newChannel ssh.NewChannel) {
+			defer wg.Done()
+			forwardChannel(client, newChannel)
+		}(newChannel)
+	}
+	wg.Wait()
+}
+
+func forwardChannel(client *ssh.Client, newChannel ssh.NewChannel) {
+	upstream, upstreamRequests, err := client.OpenChannel(newChannel.ChannelType(), newChannel.ExtraData())
+	if err != nil {
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			_ = newChannel.Reject(openErr.Reason, openErr.Message)
+		} else {
+			_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	defer upstream.Close()
+
+	downstream, downstreamRequests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer downstream.Close()
+
+	go func() {
+		_, _ = io.Copy(upstream, downstream)
+		_ = upstream.CloseWrite()
+	}()
+
+	var output sync.WaitGroup
+	output.Add(2)
+	go func() {
+		defer output.Done()
+		_, _ = io.Copy(downstream, upstream)
+	}()
+	go func() {
+		defer output.Done()
+		_, _ = io.Copy(downstream.Stderr(), upstream.Stderr())
+	}()
+
+	// requests from the client, f.i. "exec", "env", "pty-req" and "signal"
+	go func() {
+		forwardRequests(downstreamRequests, upstream)
+		upstream.Close()
+	}()
+
+	// requests from the host, f.i. "exit-status" and "exit-signal"
+	forwardRequests(upstreamRequests, downstream)
+
+	// remark that the output must be forwarded completely before the client's channel is closed
+	output.Wait()
+	_ = downstream.CloseWrite()
+}
+
+func forwardRequests(requests <-chan *ssh.Request, channel ssh.Channel) {
+	for req := range requests {
+		ok, err := channel.SendRequest(req.Type, req.WantReply, req.Payload)
+		if err != nil {
+			ok = false
+		}
+		if req.WantReply {
+			_ = req.Reply(ok, nil)
+		}
+	}
+}
+
+func dialControlMaster(c *Connection) (*ssh.Client, error) {
+	path, err := c.expandPath(c.ControlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// another local user could have put their own socket in place, to receive the scripts, the environment and the stdin
+	err = checkControlSocket(path)
+	if err != nil {
+		if c.Logf != nil && !errors.Is(err, os.ErrNotExist) {
+			c.Logf("[golang-exec/runner/ssh] skipping control master on %q: %v", path, err)
+		}
+		return nil, err
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ClientConfig{
+		User:            c.User,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // see newControlConfig()
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, path, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// the host key is ephemeral, hence the control master is verified using the version it announces, see controlVersion()
+	if string(clientConn.ServerVersion()) != controlVersion(c) {
+		clientConn.Close()
+		if c.Logf != nil {
+			c.Logf("[golang-exec/runner/ssh] skipping control master on %q, it serves another host, port or user", path)
+		}
+		return nil, fmt.Errorf("control master on %q serves another host, port or user", path)
+	}
+
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,180 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+func newControlPath(t *testing.T) string {
+	// returns a path for the socket in a private directory, see checkControlDir()
+	// remark that the path of a unix socket is limited to about 100 bytes, hence t.TempDir() is too long for subtests
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "control-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return filepath.Join(dir, "control")
+}
+
+func startControlMaster(t *testing.T, c Connection) {
+	// starts a control master for the connection, and waits until it listens
+	// remark that the control master stops when the test server is closed
+	t.Helper()
+
+	done := make(chan error, 1)
+	go func() { done <- ServeControlMaster(c) }()
+
+	for i := 0; ; i++ {
+		conn, err := net.Dial("unix", c.ControlPath)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("ServeControlMaster() failed: %v", err)
+		default:
+		}
+		if i == 100 {
+			t.Fatalf("control master not listening: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestControlMaster(t *testing.T) {
+	_, c := newTestServer(t)
+	c.ControlPath = newControlPath(t)
+	startControlMaster(t, c)
+
+	info, err := os.Stat(c.ControlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("socket has mode %v, want 0600", info.Mode().Perm())
+	}
+
+	client, err := dialControlMaster(toConnection(c))
+	if err != nil {
+		t.Fatalf("dialControlMaster() failed for the host of the control master: %v", err)
+	}
+	client.Close()
+
+	other := c
+	other.User = "other"
+	client, err = dialControlMaster(toConnection(other))
+	if err == nil {
+		client.Close()
+		t.Error("dialControlMaster() succeeded for another user")
+	}
+
+	r, err := New(c, newTestScript(t, "sh", "true\n"), nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	err = r.Run()
+	r.Close()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+}
+
+func TestControlMasterNotPrivate(t *testing.T) {
+	// a socket or a directory that other users can access or own is not used, the runner dials the host directly
+	if runtime.GOOS == "windows" {
+		t.Skip("control master is not supported on windows")
+	}
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, path string)
+	}{
+		{"socket accessible by other users", func(t *testing.T, path string) {
+			mustChmod(t, path, 0666)
+		}},
+		{"directory accessible by other users", func(t *testing.T, path string) {
+			mustChmod(t, filepath.Dir(path), 0755)
+		}},
+		{"socket owned by another user", func(t *testing.T, path string) {
+			if os.Getuid() != 0 {
+				t.Skip("changing the owner requires root")
+			}
+			err := os.Lchown(path, 65534, 65534)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"not a socket", func(t *testing.T, path string) {
+			err := os.Remove(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.WriteFile(path, nil, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, c := newTestServer(t)
+			c.ControlPath = newControlPath(t)
+			startControlMaster(t, c)
+			test.setup(t, c.ControlPath)
+
+			var logged []string
+			c.Logf = func(format string, args ...interface{}) { logged = append(logged, fmt.Sprintf(format, args...)) }
+
+			client, err := dialControlMaster(toConnection(c))
+			if err == nil {
+				client.Close()
+				t.Fatal("dialControlMaster() succeeded")
+			}
+			if len(logged) != 1 || !strings.Contains(logged[0], "skipping control master") {
+				t.Errorf("logged %q, want a message about skipping the control master", logged)
+			}
+
+			// the runner dials the host directly instead
+			r, err := New(c, newTestScript(t, "sh", "true\n"), nil)
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+			err = r.Run()
+			r.Close()
+			if err != nil {
+				t.Fatalf("Run() failed: %v", err)
+			}
+		})
+	}
+}
+
+func mustChmod(t *testing.T, path string, mode os.FileMode) {
+	t.Helper()
+
+	err := os.Chmod(path, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,69 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+//go:build !windows
+
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+//------------------------------------------------------------------------------
+
+func checkControlDir(path string) error {
+	// the directory of the socket must be owned by the current user and not accessible by other users,
+	// otherwise another local user could replace the socket by their own
+	// remark that the directory itself may be a symbolic link, f.i. "/tmp" on macOS, hence it is followed
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", dir)
+	}
+
+	return checkPrivate(dir, info)
+}
+
+func checkControlSocket(path string) error {
+	// the socket must be owned by the current user and not accessible by other users, see checkControlDir()
+	err := checkControlDir(path)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%q is not a socket", path)
+	}
+
+	return checkPrivate(path, info)
+}
+
+func checkPrivate(path string, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("cannot determine the owner of %q", path)
+	}
+	if int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%q is owned by another user", path)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%q is accessible by other users, mode %v", path, info.Mode().Perm())
+	}
+
+	return nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,27 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+//go:build windows
+
+package ssh
+
+import (
+	"fmt"
+)
+
+//------------------------------------------------------------------------------
+
+// remark that unix sockets are not supported on windows, see ServeControlMaster()
+
+func checkControlDir(path string) error {
+	return fmt.Errorf("control master is not supported on windows")
+}
+
+func checkControlSocket(path string) error {
+	return fmt.Errorf("control master is not supported on windows")
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,272 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/stefaanc/golang-exec/script"
+)
+
+//------------------------------------------------------------------------------
+
+// a jump pool keeps the clients to the jump hosts of several connections, so connections with the same 'ProxyJump'
+// share a single client to their jump host, f.i. when fanning out to hosts behind different bastions
+//
+// remark that the jump host is authenticated with the credentials of the first connection using it
+// remark that a failed dial is not kept, and a client whose connection is lost is replaced, so a retry dials the jump
+// host again
+type JumpPool struct {
+	mutex sync.Mutex
+	jumps map[string]*jumpClient
+}
+
+type jumpClient struct {
+	mutex  sync.Mutex
+	client *ssh.Client
+	lost   chan struct{} // closed when the connection of the client is closed or lost
+}
+
+//------------------------------------------------------------------------------
+
+func NewJumpPool() *JumpPool {
+	p := new(JumpPool)
+	p.jumps = make(map[string]*jumpClient)
+
+	return p
+}
+
+func (p *JumpPool) New(connection interface{}, s *script.Script, arguments interface{}) (*Runner, error) {
+	// same as New(), reusing the client to the jump host when the connection has a 'ProxyJump'
+	if s.Error != nil {
+		return nil, &Error{
+			script:   s,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/New()] script failed to parse: %#w\n", s.Error),
+		}
+	}
+
+	c := toConnection(connection)
+
+	client, err := p.newClient(c)
+	if err != nil {
+		return nil, &Error{
+			script:   s,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/New()] %#w\n", err),
+		}
+	}
+
+	r, err := newRunner(client, c, s, arguments)
+	if err != nil {
+		client.Close()
+		return nil, &Error{
+			script:   s,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/New()] %#w\n", err),
+		}
+	}
+	r.ownsClient = true
+
+	return r, nil
+}
+
+func (p *JumpPool) NewClient(connection interface{}) (*ssh.Client, error) {
+	// same as NewClient(), reusing the client to the jump host when the connection has a 'ProxyJump'
+	// remark that the caller is responsible for closing the client, the clients to the jump hosts are closed by Close()
+	client, err := p.newClient(toConnection(connection))
+	if err != nil {
+		return nil, &Error{
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/NewClient()] %#w\n", err),
+		}
+	}
+
+	return client, nil
+}
+
+func (p *JumpPool) newClient(c *Connection) (*ssh.Client, error) {
+	if len(c.ProxyJump) == 0 {
+		return newClient(c)
+	}
+
+	jc, err := c.jumpConnection()
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s@%s:%d", jc.User, jc.Host, jc.Port)
+
+	p.mutex.Lock()
+	j, ok := p.jumps[key]
+	if !ok {
+		j = new(jumpClient)
+		p.jumps[key] = j
+	}
+	p.mutex.Unlock()
+
+	jump, err := j.get(jc)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to jump host %q: %w", c.ProxyJump, err)
+	}
+
+	return newClientVia(c, jump)
+}
+
+func (j *jumpClient) get(jc *Connection) (*ssh.Client, error) {
+	// returns the client to the jump host, dialing it when there is no client yet or its connection is lost
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.client != nil {
+		select {
+		case <-j.lost:
+			j.client = nil
+		default:
+			return j.client, nil
+		}
+	}
+
+	client, err := newClient(jc)
+	if err != nil {
+		return nil, err
+	}
+
+	lost := make(chan struct{})
+	go func() {
+		_ = client.Wait()
+		close(lost)
+	}()
+	j.client = client
+	j.lost = lost
+
+	return client, nil
+}
+
+func (p *JumpPool) Close() error {
+	// closes the clients to the jump hosts, hence also the clients tunneled through them
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for key, j := range p.jumps {
+		j.mutex.Lock()
+		if j.client != nil {
+			j.client.Close()
+		}
+		j.mutex.Unlock()
+		delete(p.jumps, key)
+	}
+
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+func (c *Connection) jumpConnection() (*Connection, error) {
+	// returns the connection to the jump host, with the user and the port of the 'ProxyJump' when specified
+	// the other settings, f.i. the credentials, are the same as for the host
+	// remark that the 'ExpectedFingerprint' and the 'TrustStore' are meant for the key of the host, not the key of the
+	// jump host, hence the key of the jump host is verified using the 'known_hosts'-files, or not at all with 'Insecure'
+	jc := c.Clone()
+	jc.ProxyJump = ""
+	jc.ExpectedFingerprint = ""
+	jc.TrustStore = nil
+	jc.ControlPath = ""
+	jc.Port = DefaultPort
+
+	address := c.ProxyJump
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		jc.User = address[:i]
+		address = address[i+1:]
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host = strings.Trim(address, "[]")
+	} else {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || p == 0 {
+			return nil, fmt.Errorf("invalid port in 'ProxyJump' %q in 'connection' parameter", c.ProxyJump)
+		}
+		jc.Port = uint16(p)
+	}
+	if len(host) == 0 {
+		return nil, fmt.Errorf("missing host in 'ProxyJump' %q in 'connection' parameter", c.ProxyJump)
+	}
+	jc.Host = host
+
+	return jc, nil
+}
+
+func dialJump(c *Connection, jump *ssh.Client, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	// tunnels the connection to the host through the jump host, like OpenSSH's "ProxyJump" option
+	// when jump is nil, a client to the jump host is created, and closed when the client to the host is closed
+	//
+	// remark that the 'DialTimeout', the 'Network' and the socket options apply to the connection to the jump host only
+	ownsJump := jump == nil
+	if ownsJump {
+		jc, err := c.jumpConnection()
+		if err != nil {
+			return nil, err
+		}
+
+		jump, err = newClient(jc)
+		if err != nil {
+			return nil, fmt.Errorf("cannot connect to jump host %q: %w", c.ProxyJump, err)
+		}
+	}
+
+	conn, err := jump.Dial("tcp", address)
+	if err != nil {
+		if ownsJump {
+			jump.Close()
+		}
+		return nil, fmt.Errorf("cannot tunnel through jump host %q: %w", c.ProxyJump, err)
+	}
+
+	// the tunnel doesn't support deadlines, hence the handshake is aborted by closing the tunnel
+	// remark that the network errors are recorded to keep these unwrappable, see dial()
+	recorder := &errorRecordingConn{Conn: conn}
+	if _, handshakeTimeout := c.timeouts(); handshakeTimeout > 0 {
+		timer := time.AfterFunc(handshakeTimeout, func() {
+			recorder.record(os.ErrDeadlineExceeded)
+			conn.Close()
+		})
+		defer timer.Stop()
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(recorder, address, config)
+	if err != nil {
+		conn.Close()
+		if ownsJump {
+			jump.Close()
+		}
+		if cause := recorder.Err(); cause != nil {
+			return nil, &handshakeError{err: err, cause: cause}
+		}
+		return nil, err
+	}
+	client := ssh.NewClient(clientConn, chans, reqs)
+
+	if ownsJump {
+		go func() {
+			_ = client.Wait()
+			jump.Close()
+		}()
+	}
+
+	return client, nil
+}
+
+//------------------------------------------------------------------------------
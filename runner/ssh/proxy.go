@@ -0,0 +1,195 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/stefaanc/golang-exec/internal/output"
+	"github.com/stefaanc/golang-exec/script"
+)
+
+//------------------------------------------------------------------------------
+
+// a connection over the stdin and stdout of a local proxy command, f.i. "nc -X connect -x proxy:3128 %h %p"
+// closing the connection kills the proxy command
+type proxyCommandConn struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	stdout    io.ReadCloser
+	stderr    *output.HeadBuffer
+	closeOnce sync.Once
+}
+
+type proxyCommandAddr struct {
+	command string
+}
+
+//------------------------------------------------------------------------------
+
+func dialProxyCommand(c *Connection, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	// tunnels the connection to the host through the stdin and stdout of a local command, like OpenSSH's "ProxyCommand" option
+	// "%h", "%p" and "%r" in the command are replaced by the host, the port and the user, "%%" by a literal "%"
+	// remark that the host, the port and the user are quoted for "sh", so these cannot inject shell syntax, hence these
+	// must not be quoted in the command - on windows, these are not quoted, cmd has no reliable quoting
+	//
+	// remark that the 'DialTimeout', the 'Network' and the socket options don't apply, the proxy command makes the connection
+	// remark that the command runs with "sh -c", or "cmd /C" on windows
+	if c.NoDelay != nil {
+		return nil, fmt.Errorf("'NoDelay' in 'connection' parameter is not supported with 'ProxyCommand', the proxy command makes the connection")
+	}
+
+	command := proxyCommand(c)
+
+	conn, err := startProxyCommand(command)
+	if err != nil {
+		return nil, fmt.Errorf("cannot start proxy command %q: %w", command, err)
+	}
+
+	// the pipes don't support deadlines, hence the handshake is aborted by closing the connection
+	// remark that the errors of the pipes are recorded to keep these unwrappable, see dial()
+	recorder := &errorRecordingConn{Conn: conn}
+	if _, handshakeTimeout := c.timeouts(); handshakeTimeout > 0 {
+		timer := time.AfterFunc(handshakeTimeout, func() {
+			recorder.record(os.ErrDeadlineExceeded)
+			conn.Close()
+		})
+		defer timer.Stop()
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(recorder, address, config)
+	if err != nil {
+		conn.Close()
+		if stderr := conn.stderr.Lines(10); len(stderr) > 0 {
+			err = fmt.Errorf("%w, proxy command stderr: \n%s", err, stderr)
+		}
+		if cause := recorder.Err(); cause != nil {
+			return nil, &handshakeError{err: err, cause: cause}
+		}
+		return nil, err
+	}
+
+	// the client closes the connection when it is closed or the connection is lost, this kills the proxy command
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+func proxyCommand(c *Connection) string {
+	quoteValue := func(s string) string { return script.Quote("sh", s) }
+	if runtime.GOOS == "windows" {
+		quoteValue = func(s string) string { return s }
+	}
+
+	replacer := strings.NewReplacer(
+		"%%", "%",
+		"%h", quoteValue(c.Host),
+		"%p", quoteValue(strconv.Itoa(int(c.Port))),
+		"%r", quoteValue(c.User),
+	)
+
+	return replacer.Replace(c.ProxyCommand)
+}
+
+func startProxyCommand(command string) (*proxyCommandConn, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		// exec, so killing the shell kills the proxy
+		cmd = exec.Command("sh", "-c", "exec "+command)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		stdin.Close()
+		return nil, err
+	}
+
+	conn := &proxyCommandConn{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: &output.HeadBuffer{Size: 4096},
+	}
+	cmd.Stderr = conn.stderr
+
+	err = cmd.Start()
+	if err != nil {
+		stdin.Close()
+		stdout.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+//------------------------------------------------------------------------------
+
+func (p *proxyCommandConn) Read(b []byte) (int, error) {
+	return p.stdout.Read(b)
+}
+
+func (p *proxyCommandConn) Write(b []byte) (int, error) {
+	return p.stdin.Write(b)
+}
+
+func (p *proxyCommandConn) Close() error {
+	p.closeOnce.Do(func() {
+		p.stdin.Close()
+		if p.cmd.Process != nil {
+			_ = p.cmd.Process.Kill()
+		}
+		// reap the process, the exit status of a killed proxy command is not interesting
+		_ = p.cmd.Wait()
+	})
+
+	return nil
+}
+
+func (p *proxyCommandConn) LocalAddr() net.Addr {
+	return proxyCommandAddr{command: strings.Join(p.cmd.Args, " ")}
+}
+
+func (p *proxyCommandConn) RemoteAddr() net.Addr {
+	return proxyCommandAddr{command: strings.Join(p.cmd.Args, " ")}
+}
+
+func (p *proxyCommandConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (p *proxyCommandConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (p *proxyCommandConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (a proxyCommandAddr) Network() string {
+	return "proxycommand"
+}
+
+func (a proxyCommandAddr) String() string {
+	return a.command
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,906 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package ssh
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/stefaanc/golang-exec/internal/sshtest"
+	"github.com/stefaanc/golang-exec/script"
+)
+
+//------------------------------------------------------------------------------
+
+func newTestServer(t *testing.T) (*sshtest.Server, Connection) {
+	// returns an in-process ssh server, and a connection to it that pins its host key
+	// remark that the server is closed after the runners, these must be closed by the test itself
+	t.Helper()
+
+	srv, err := sshtest.NewServer("test", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	c := Connection{
+		Type:                "ssh",
+		Host:                srv.Host,
+		Port:                srv.Port,
+		User:                srv.User,
+		Password:            srv.Password,
+		ExpectedFingerprint: ssh.FingerprintSHA256(srv.HostKey()),
+	}
+
+	return srv, c
+}
+
+func newTestScript(t *testing.T, shell string, code string) *script.Script {
+	t.Helper()
+
+	s, err := script.NewFromString("test", shell, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+//------------------------------------------------------------------------------
+
+func TestAuthSucceeds(t *testing.T) {
+	_, c := newTestServer(t)
+
+	r, err := New(c, newTestScript(t, "sh", "true\n"), nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+
+	err = r.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+}
+
+func TestAuthFails(t *testing.T) {
+	_, c := newTestServer(t)
+	c.Password = "wrong"
+
+	r, err := New(c, newTestScript(t, "sh", "true\n"), nil)
+	if err == nil {
+		r.Close()
+		t.Fatal("New() succeeded with a wrong password")
+	}
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("New() returned %v, want ErrAuthFailed", err)
+	}
+
+	var runnerErr *Error
+	if !errors.As(err, &runnerErr) || runnerErr.Stage() != StageSetup {
+		t.Errorf("New() returned %v, want an *Error in stage %q", err, StageSetup)
+	}
+}
+
+func TestAuthTimesOut(t *testing.T) {
+	// a timeout during the authentication is not an authentication failure, the network error must stay unwrappable
+	srv, c := newTestServer(t)
+	srv.AuthDelay = time.Second
+	c.HandshakeTimeout = 100 * time.Millisecond
+
+	r, err := New(c, newTestScript(t, "sh", "true\n"), nil)
+	if err == nil {
+		r.Close()
+		t.Fatal("New() succeeded, want a handshake timeout")
+	}
+	if errors.Is(err, ErrAuthFailed) {
+		t.Errorf("New() returned %v, want no ErrAuthFailed", err)
+	}
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("New() returned %v, want a net.Error that timed out", err)
+	}
+}
+
+func TestRunExitCode(t *testing.T) {
+	_, c := newTestServer(t)
+
+	tests := []struct {
+		code     string
+		exitCode int
+	}{
+		{"exit 0\n", 0},
+		{"exit 3\n", 3},
+		{"false\n", 1},
+	}
+	for _, test := range tests {
+		r, err := New(c, newTestScript(t, "sh", test.code), nil)
+		if err != nil {
+			t.Fatalf("New() failed: %v", err)
+		}
+
+		err = r.Run()
+		r.Close()
+		if r.ExitCode() != test.exitCode {
+			t.Errorf("%q: ExitCode() = %d, want %d", test.code, r.ExitCode(), test.exitCode)
+		}
+		if test.exitCode == 0 {
+			if err != nil {
+				t.Errorf("%q: Run() failed: %v", test.code, err)
+			}
+			continue
+		}
+
+		var runnerErr *Error
+		if !errors.As(err, &runnerErr) {
+			t.Fatalf("%q: Run() returned %v, want an *Error", test.code, err)
+		}
+		if runnerErr.ExitCode() != test.exitCode || runnerErr.Stage() != StageCommand {
+			t.Errorf("%q: Run() returned exit code %d in stage %q, want %d in stage %q", test.code, runnerErr.ExitCode(), runnerErr.Stage(), test.exitCode, StageCommand)
+		}
+	}
+}
+
+func TestRunSeparatesStdoutAndStderr(t *testing.T) {
+	_, c := newTestServer(t)
+
+	r, err := New(c, newTestScript(t, "sh", "echo out-{{.}}\necho err-{{.}} >&2\n"), "x")
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+
+	var stdout, stderr bytes.Buffer
+	r.SetStdoutWriter(&stdout)
+	r.SetStderrWriter(&stderr)
+
+	err = r.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if stdout.String() != "out-x\n" {
+		t.Errorf("stdout = %q, want %q", stdout.String(), "out-x\n")
+	}
+	if stderr.String() != "err-x\n" {
+		t.Errorf("stderr = %q, want %q", stderr.String(), "err-x\n")
+	}
+}
+
+func TestRunStdinClosedEarly(t *testing.T) {
+	// the command reads one line and exits, so the remaining stdin cannot be copied to the session
+	_, c := newTestServer(t)
+
+	r, err := New(c, newTestScript(t, "raw", "head -n 1"), nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+
+	// more than the 2MB window of the channel, so the copy is still busy when the command exits
+	stdin := "first line\n" + strings.Repeat("another line\n", 1<<20)
+	err = r.SetStdinReaders(strings.NewReader(stdin))
+	if err != nil {
+		t.Fatalf("SetStdinReaders() failed: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	r.SetStdoutWriter(&stdout)
+
+	err = r.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if r.ExitCode() != 0 {
+		t.Errorf("ExitCode() = %d, want 0", r.ExitCode())
+	}
+	if stdout.String() != "first line\n" {
+		t.Errorf("stdout = %q, want %q", stdout.String(), "first line\n")
+	}
+}
+
+func TestRunExecRejected(t *testing.T) {
+	// a rejected exec request is not a failing command, f.i. for a server with a restricted 'ForceCommand'
+	srv, c := newTestServer(t)
+	srv.RejectExec = true
+
+	r, err := New(c, newTestScript(t, "sh", "true\n"), nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+
+	err = r.Run()
+	var runnerErr *Error
+	if !errors.As(err, &runnerErr) {
+		t.Fatalf("Run() returned %v, want an *Error", err)
+	}
+	if runnerErr.Stage() != StageExec {
+		t.Errorf("Stage() = %q, want %q", runnerErr.Stage(), StageExec)
+	}
+	if runnerErr.ExitCode() != -1 {
+		t.Errorf("ExitCode() = %d, want -1", runnerErr.ExitCode())
+	}
+	if !strings.Contains(err.Error(), "exec request rejected by host") {
+		t.Errorf("Run() returned %q, want a message about the rejected exec request", err.Error())
+	}
+}
+
+func TestRunFishWithEnv(t *testing.T) {
+	// fish reads the script from stdin, the environment is set using "env" requests, so it doesn't depend on the shell
+	srv, c := newTestServer(t)
+	c.Env = map[string]string{"GREETING": "hello world"}
+
+	var command, stdin string
+	var env []string
+	srv.Exec = func(cmd string, e []string, in io.Reader, stdout, stderr io.Writer) int {
+		b, _ := io.ReadAll(in)
+		command, stdin, env = cmd, string(b), e
+		return 0
+	}
+
+	r, err := New(c, newTestScript(t, "fish", "set -x NAME {{.}}\necho $GREETING $NAME\n"), "fish")
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+
+	err = r.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if command != "fish" {
+		t.Errorf("command = %q, want %q", command, "fish")
+	}
+	if want := "set -x NAME fish\necho $GREETING $NAME\n"; stdin != want {
+		t.Errorf("stdin = %q, want %q", stdin, want)
+	}
+	if len(env) != 1 || env[0] != "GREETING=hello world" {
+		t.Errorf("env = %q, want %q", env, []string{"GREETING=hello world"})
+	}
+}
+
+func TestToConnectionPort(t *testing.T) {
+	// the port is read from a string field too, f.i. when the struct is loaded from a text config
+	// remark that an invalid port falls back to the default port 22
+	type stringPort struct {
+		Type     string
+		Host     string
+		Port     string
+		User     string
+		Password string
+		Insecure bool
+	}
+	type intPort struct {
+		Type     string
+		Host     string
+		Port     int
+		User     string
+		Password string
+		Insecure bool
+	}
+	type uintPort struct {
+		Type     string
+		Host     string
+		Port     uint16
+		User     string
+		Password string
+		Insecure bool
+	}
+
+	tests := []struct {
+		name       string
+		connection interface{}
+		port       uint16
+	}{
+		{"string", stringPort{Port: "2222"}, 2222},
+		{"string with spaces", stringPort{Port: " 2222 "}, 2222},
+		{"pointer to string", &stringPort{Port: "2222"}, 2222},
+		{"empty string", stringPort{Port: ""}, 22},
+		{"invalid string", stringPort{Port: "ssh"}, 22},
+		{"string out of range", stringPort{Port: "65536"}, 22},
+		{"int", intPort{Port: 2222}, 2222},
+		{"negative int", intPort{Port: -1}, 22},
+		{"uint16", uintPort{Port: 2222}, 2222},
+		{"map", map[string]string{"Port": "2222"}, 2222},
+	}
+	for _, test := range tests {
+		c := toConnection(test.connection)
+		if c.Port != test.port {
+			t.Errorf("%s: Port = %d, want %d", test.name, c.Port, test.port)
+		}
+	}
+}
+
+func TestNewWithStringPort(t *testing.T) {
+	srv, c := newTestServer(t)
+
+	connection := struct {
+		Type                string
+		Host                string
+		Port                string
+		User                string
+		Password            string
+		Insecure            bool
+		ExpectedFingerprint string
+	}{"ssh", srv.Host, strconv.Itoa(int(srv.Port)), srv.User, srv.Password, false, c.ExpectedFingerprint}
+
+	r, err := New(connection, newTestScript(t, "sh", "true\n"), nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+
+	err = r.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+}
+
+func TestProxyJumpHostKey(t *testing.T) {
+	// the 'ExpectedFingerprint' is the fingerprint of the host, the key of the jump host is verified using the 'known_hosts'-file
+	jump, _ := newTestServer(t)
+	jump.ForwardTCP = true
+	_, c := newTestServer(t)
+	c.ProxyJump = net.JoinHostPort(jump.Host, strconv.Itoa(int(jump.Port)))
+
+	c.KnownHostsPaths = []string{writeKnownHosts(t, c.ProxyJump, jump.HostKey())}
+
+	r, err := New(c, newTestScript(t, "sh", "true\n"), nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+
+	err = r.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+}
+
+func TestProxyCommandQuoting(t *testing.T) {
+	// the host, the port and the user are single arguments, even when these contain shell syntax
+	if runtime.GOOS == "windows" {
+		t.Skip("the values are not quoted for cmd")
+	}
+
+	c := &Connection{
+		Host:         "host; echo injected",
+		Port:         2222,
+		User:         "it's $(id)",
+		ProxyCommand: "printf '[%%s]' %h %p %r",
+	}
+
+	output, err := exec.Command("sh", "-c", proxyCommand(c)).Output()
+	if err != nil {
+		t.Fatalf("proxy command failed: %v", err)
+	}
+	if want := "[host; echo injected][2222][it's $(id)]"; string(output) != want {
+		t.Errorf("proxy command printed %q, want %q", string(output), want)
+	}
+}
+
+func TestDetectPlatformEvicted(t *testing.T) {
+	// the platform is cached per client, until the client is closed, also when the runner doesn't own the client
+	_, c := newTestServer(t)
+
+	client, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	defer client.Close()
+
+	r, err := NewWithClient(client, c, newTestScript(t, "sh", "true\n"), nil)
+	if err != nil {
+		t.Fatalf("NewWithClient() failed: %v", err)
+	}
+	defer r.Close()
+
+	goos, goarch, err := r.DetectPlatform()
+	if err != nil {
+		t.Fatalf("DetectPlatform() failed: %v", err)
+	}
+	if goos != runtime.GOOS {
+		t.Errorf("DetectPlatform() = %q, %q, want os %q", goos, goarch, runtime.GOOS)
+	}
+	if _, ok := platforms.load(client, ""); !ok {
+		t.Fatal("platform not cached")
+	}
+
+	client.Close()
+	waitEvicted(t, &platforms, client, "")
+}
+
+func TestEnsureShellEvicted(t *testing.T) {
+	// the verified shell is cached per client, until the client is closed, also when the runner doesn't own the client
+	_, c := newTestServer(t)
+	c.EnsureShell = true
+
+	client, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	defer client.Close()
+
+	r, err := NewWithClient(client, c, newTestScript(t, "sh", "true\n"), nil)
+	if err != nil {
+		t.Fatalf("NewWithClient() failed: %v", err)
+	}
+	defer r.Close()
+
+	if _, ok := shells.load(client, "sh"); !ok {
+		t.Fatal("shell not cached")
+	}
+
+	client.Close()
+	waitEvicted(t, &shells, client, "sh")
+}
+
+func waitEvicted(t *testing.T, cc *clientCache, client *ssh.Client, key string) {
+	// the values are evicted by a goroutine when the client is closed
+	t.Helper()
+
+	for i := 0; ; i++ {
+		if _, ok := cc.load(client, key); !ok {
+			return
+		}
+		if i == 100 {
+			t.Fatal("value not evicted after the client was closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJumpPoolRedials(t *testing.T) {
+	// a failed dial to the jump host and a lost connection to the jump host are not kept, the next runner dials again
+	jump, _ := newTestServer(t)
+	jump.ForwardTCP = true
+	jump.RejectAuth = 1
+	_, c := newTestServer(t)
+	c.ProxyJump = net.JoinHostPort(jump.Host, strconv.Itoa(int(jump.Port)))
+	c.KnownHostsPaths = []string{writeKnownHosts(t, c.ProxyJump, jump.HostKey())}
+
+	pool := NewJumpPool()
+	defer pool.Close()
+
+	run := func() error {
+		r, err := pool.New(c, newTestScript(t, "sh", "true\n"), nil)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return r.Run()
+	}
+
+	err := run()
+	if err == nil {
+		t.Fatal("first run succeeded, want the jump host to reject the authentication")
+	}
+	err = run()
+	if err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+
+	jump.CloseConnections()
+	for i := 0; ; i++ {
+		err = run()
+		if err == nil {
+			break
+		}
+		// the lost connection may not be detected yet
+		if i == 100 {
+			t.Fatalf("run after the connection to the jump host was lost failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func writeKnownHosts(t *testing.T, address string, key ssh.PublicKey) string {
+	// returns the path of a 'known_hosts'-file with the key of the host
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(address)}, key)
+	err := os.WriteFile(path, []byte(line+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestParseConnectionURLKeyPath(t *testing.T) {
+	// a "~" in the 'key_path' is expanded, and the error of loading the key is kept
+	home := t.TempDir()
+	err := os.Mkdir(filepath.Join(home, ".ssh"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(home, ".ssh", "id_ecdsa"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ParseConnectionURL("ssh://test@localhost?home_dir=" + url.QueryEscape(home) + "&key_path=~/.ssh/id_ecdsa")
+	if err != nil {
+		t.Fatalf("ParseConnectionURL() failed: %v", err)
+	}
+	if c.PubKey == nil {
+		t.Error("ParseConnectionURL() didn't load the key")
+	}
+	if c.PubKeyPath != "~/.ssh/id_ecdsa" {
+		t.Errorf("PubKeyPath = %q, want %q", c.PubKeyPath, "~/.ssh/id_ecdsa")
+	}
+
+	_, err = ParseConnectionURL("ssh://test@localhost?home_dir=" + url.QueryEscape(home) + "&key_path=~/.ssh/missing")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ParseConnectionURL() returned %v, want an error wrapping os.ErrNotExist", err)
+	}
+}
+
+func TestOutputFilterWithEncoding(t *testing.T) {
+	// the filters write to the decoders, so a partial last line held back by a filter must reach the decoder before it is closed
+	_, c := newTestServer(t)
+	c.OutputEncoding = "utf-8"
+
+	r, err := New(c, newTestScript(t, "sh", "printf 'password=secret\\ncaf\\303'\n"), nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+
+	var stdout bytes.Buffer
+	r.SetStdoutWriter(&stdout)
+	r.SetOutputFilter(func(line string) string { return strings.ReplaceAll(line, "secret", "***") }, true)
+
+	err = r.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if want := "password=***\ncaf\uFFFD"; stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+}
+
+func TestQueryAuthMethods(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	methods, err := QueryAuthMethods(srv.Host, srv.Port, srv.User)
+	if err != nil {
+		t.Fatalf("QueryAuthMethods() failed: %v", err)
+	}
+	if want := []string{"password"}; strings.Join(methods, ",") != strings.Join(want, ",") {
+		t.Errorf("QueryAuthMethods() = %q, want %q", methods, want)
+	}
+}
+
+func TestQueryAuthMethodsHandshakeTimeout(t *testing.T) {
+	// a host that accepts the connection but never starts the handshake
+	defer func(timeout time.Duration) { queryTimeout = timeout }(queryTimeout)
+	queryTimeout = 200 * time.Millisecond
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	done := make(chan error, 1)
+	go func() {
+		_, err := QueryAuthMethods("127.0.0.1", port, "test")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("QueryAuthMethods() succeeded, want an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("QueryAuthMethods() didn't time out")
+	}
+}
+
+func recordCommands(srv *sshtest.Server) func() []string {
+	// wraps the 'Exec' handler of the server, and returns a function that returns the commands executed so far
+	var mutex sync.Mutex
+	var commands []string
+
+	exec := srv.Exec
+	srv.Exec = func(command string, env []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		mutex.Lock()
+		commands = append(commands, command)
+		mutex.Unlock()
+		return exec(command, env, stdin, stdout, stderr)
+	}
+
+	return func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), commands...)
+	}
+}
+
+func TestStageScript(t *testing.T) {
+	// the script is executed from a file in the 'RemoteTempDir', the file is removed afterwards
+	for _, compress := range []bool{false, true} {
+		srv, c := newTestServer(t)
+		commands := recordCommands(srv)
+		dir := t.TempDir()
+		c.StageScript = true
+		c.RemoteTempDir = dir
+		c.CompressStagedScript = compress
+
+		r, err := New(c, newTestScript(t, "sh", "echo \"$0\"\n"), nil)
+		if err != nil {
+			t.Fatalf("compress %v: New() failed: %v", compress, err)
+		}
+		r.SetCaptureOutput(true)
+
+		err = r.Run()
+		r.Close()
+		if err != nil {
+			t.Fatalf("compress %v: Run() failed: %v", compress, err)
+		}
+		if path := strings.TrimSpace(r.CapturedStdout()); filepath.Dir(path) != dir || !strings.HasSuffix(path, ".sh") {
+			t.Errorf("compress %v: script executed from %q, want a file in %q", compress, path, dir)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("compress %v: %d files left in %q, want the staged script removed", compress, len(entries), dir)
+		}
+
+		gunzip := false
+		for _, command := range commands() {
+			gunzip = gunzip || strings.Contains(command, "gunzip -c")
+		}
+		if gunzip != compress {
+			t.Errorf("compress %v: uploaded using gunzip = %v, want %v", compress, gunzip, compress)
+		}
+	}
+}
+
+func TestStageScriptKeepStagedOnError(t *testing.T) {
+	_, c := newTestServer(t)
+	dir := t.TempDir()
+	c.StageScript = true
+	c.RemoteTempDir = dir
+	c.KeepStagedOnError = true
+
+	var logged []string
+	c.Logf = func(format string, args ...interface{}) { logged = append(logged, fmt.Sprintf(format, args...)) }
+
+	r, err := New(c, newTestScript(t, "sh", "exit 3\n"), nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	err = r.Run()
+	r.Close()
+	if err == nil || r.ExitCode() != 3 {
+		t.Fatalf("Run() returned %v with exit code %d, want exit code 3", err, r.ExitCode())
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("%d files left in %q, want the staged script", len(entries), dir)
+	}
+	code, _ := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if !strings.Contains(string(code), "exit 3") {
+		t.Errorf("staged script = %q, want the rendered script", string(code))
+	}
+	if len(logged) != 1 || !strings.Contains(logged[0], entries[0].Name()) {
+		t.Errorf("logged %q, want the path of the staged script", logged)
+	}
+}
+
+func TestDetach(t *testing.T) {
+	// Run() returns once the command is launched, the command keeps running after the session
+	_, c := newTestServer(t)
+	c.Detach = true
+	path := filepath.Join(t.TempDir(), "done")
+
+	r, err := New(c, newTestScript(t, "sh", "sleep 0.5\necho done > {{ . }}\n"), path)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+
+	err = r.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Fatal("Run() returned after the command finished, want it to return once the command is launched")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		output, _ := os.ReadFile(path)
+		if string(output) == "done\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the detached command didn't finish")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestCapturePID(t *testing.T) {
+	// the PID is removed from stderr, and is the PID of the shell executing the script
+	_, c := newTestServer(t)
+	c.CapturePID = true
+
+	r, err := New(c, newTestScript(t, "sh", "echo $$\necho err >&2\n"), nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+	r.SetCaptureOutput(true)
+
+	err = r.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	pid, err := r.RemotePID()
+	if err != nil {
+		t.Fatalf("RemotePID() failed: %v", err)
+	}
+	if want := strconv.Itoa(pid) + "\n"; r.CapturedStdout() != want {
+		t.Errorf("RemotePID() = %d, want the PID of the shell %q", pid, r.CapturedStdout())
+	}
+	if r.CapturedStderr() != "err\n" {
+		t.Errorf("stderr = %q, want %q", r.CapturedStderr(), "err\n")
+	}
+}
+
+func TestRemoteEnv(t *testing.T) {
+	// the variables set using 'Env' are included, unset variables are missing
+	_, c := newTestServer(t)
+	c.Env = map[string]string{"GOLANG_EXEC_TEST": "two words\nand a line"}
+
+	r, err := New(c, newTestScript(t, "sh", "true\n"), nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+
+	env, err := r.RemoteEnv("GOLANG_EXEC_TEST", "GOLANG_EXEC_UNSET")
+	if err != nil {
+		t.Fatalf("RemoteEnv() failed: %v", err)
+	}
+	if len(env) != 1 || env["GOLANG_EXEC_TEST"] != c.Env["GOLANG_EXEC_TEST"] {
+		t.Errorf("RemoteEnv() = %q, want %q", env, c.Env)
+	}
+
+	_, err = r.RemoteEnv("NOT-A-NAME")
+	if err == nil {
+		t.Error("RemoteEnv() succeeded for an invalid name, want an error")
+	}
+}
+
+func TestExitCodeMarker(t *testing.T) {
+	// the exit code is parsed from the marker line, the marker line is removed from the output
+	_, c := newTestServer(t)
+	c.ExitCodeMarker = "EXIT:"
+
+	r, err := New(c, newTestScript(t, "sh", "echo out\necho EXIT:5\nexit 0\n"), nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+	r.SetCaptureOutput(true)
+
+	err = r.Run()
+	if err == nil || r.ExitCode() != 5 {
+		t.Errorf("Run() returned %v with exit code %d, want exit code 5", err, r.ExitCode())
+	}
+	if r.CapturedStdout() != "out\n" {
+		t.Errorf("stdout = %q, want %q", r.CapturedStdout(), "out\n")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	// the timeout is reset by the output, so only a command that stops producing output is killed
+	tests := []struct {
+		code string
+		err  error
+	}{
+		{"for i in 1 2 3 4 5; do echo $i; sleep 0.1; done\n", nil},
+		{"echo start\nsleep 1\n", ErrIdleTimeout},
+	}
+	for _, test := range tests {
+		_, c := newTestServer(t)
+		c.IdleTimeout = 300 * time.Millisecond
+
+		r, err := New(c, newTestScript(t, "sh", test.code), nil)
+		if err != nil {
+			t.Fatalf("New() failed: %v", err)
+		}
+
+		err = r.Run()
+		r.Close()
+		if test.err == nil {
+			if err != nil {
+				t.Errorf("%q: Run() failed: %v", test.code, err)
+			}
+			continue
+		}
+		if !errors.Is(err, test.err) || r.ExitCode() != -1 {
+			t.Errorf("%q: Run() returned %v with exit code %d, want %v with exit code -1", test.code, err, r.ExitCode(), test.err)
+		}
+	}
+}
+
+func TestKeepAliveInterval(t *testing.T) {
+	// the connection is lost when the host doesn't reply to the keepalive requests
+	for _, drop := range []bool{false, true} {
+		srv, c := newTestServer(t)
+		srv.DropGlobal = drop
+		c.KeepAliveInterval = 50 * time.Millisecond
+		c.KeepAliveMaxMissed = 2
+
+		r, err := New(c, newTestScript(t, "sh", "sleep 0.5\n"), nil)
+		if err != nil {
+			t.Fatalf("New() failed: %v", err)
+		}
+
+		err = r.Run()
+		r.Close()
+		if !drop {
+			if err != nil {
+				t.Errorf("replying host: Run() failed: %v", err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrConnectionLost) || r.ExitCode() != -1 {
+			t.Errorf("dropping host: Run() returned %v with exit code %d, want %v with exit code -1", err, r.ExitCode(), ErrConnectionLost)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,478 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/stefaanc/golang-exec/script"
+)
+
+//------------------------------------------------------------------------------
+
+// the time to wait for the login output to complete, see 'SuppressMotd'
+const motdTimeout = 10 * time.Second
+
+// a shell keeps a single interactive shell session open, to send multiple commands to
+//
+// remark that there is no reliable way to detect when a command sent to an interactive shell completes:
+// - the shell doesn't report exit codes for individual commands, only for the shell itself when it exits
+// - output may arrive in multiple chunks, with pauses in between
+// - prompts may or may not be printed, depending on the shell and whether a pty was requested
+// a common approach is to echo a unique marker after each command, and to wait until the marker appears in the output
+type Shell struct {
+	client     *ssh.Client
+	session    *ssh.Session
+	stdin      io.WriteCloser
+	ownsClient bool
+
+	mutex    sync.Mutex
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+	updated  chan struct{}
+	drained  sync.WaitGroup
+	finished chan struct{} // closed when the output is drained, f.i. because the shell exited
+}
+
+//------------------------------------------------------------------------------
+
+func NewShell(connection interface{}) (*Shell, error) {
+	c := toConnection(connection)
+
+	client, err := newClient(c)
+	if err != nil {
+		return nil, &Error{
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/NewShell()] %#w\n", err),
+		}
+	}
+
+	sh, err := newShell(client, c)
+	if err != nil {
+		client.Close()
+		return nil, &Error{
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/NewShell()] %#w\n", err),
+		}
+	}
+	sh.ownsClient = true
+
+	return sh, nil
+}
+
+func newShell(client *ssh.Client, c *Connection) (*Shell, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("cannot open session: %w", err)
+	}
+
+	sh := new(Shell)
+	sh.client = client
+	sh.session = session
+	sh.updated = make(chan struct{}, 1)
+	sh.finished = make(chan struct{})
+
+	sh.stdin, err = session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("cannot create stdin writer: %w", err)
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("cannot create stdout reader: %w", err)
+	}
+
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("cannot create stderr reader: %w", err)
+	}
+
+	if c.Pty {
+		modes := ssh.TerminalModes{
+			ssh.ECHO: 0,
+		}
+		err = session.RequestPty("xterm", 40, 80, modes)
+		if err != nil {
+			session.Close()
+			return nil, fmt.Errorf("cannot request pty: %w", err)
+		}
+	}
+
+	err = session.Shell()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("cannot start shell: %w", err)
+	}
+
+	// drain the output continuously, so the remote never blocks on a full channel
+	sh.drained.Add(2)
+	go sh.drain(stdout, &sh.stdout)
+	go sh.drain(stderr, &sh.stderr)
+	go func() {
+		sh.drained.Wait()
+		close(sh.finished)
+	}()
+
+	if c.SuppressMotd {
+		err = sh.suppressMotd()
+		if err != nil {
+			sh.Close()
+			return nil, err
+		}
+	}
+
+	return sh, nil
+}
+
+func (sh *Shell) suppressMotd() error {
+	// an interactive shell prints the message of the day, the last login, prompts,... before reading the first line
+	// hence we echo a marker, and discard everything up to and including the marker
+	//
+	// remark that an exec request doesn't start an interactive login, so the runner's output doesn't include this
+	random := make([]byte, 8)
+	_, err := rand.Read(random)
+	if err != nil {
+		return fmt.Errorf("cannot create marker: %w", err)
+	}
+	marker := fmt.Sprintf("_motd-%x", random)
+
+	_, err = io.WriteString(sh.stdin, "echo "+marker+"\n")
+	if err != nil {
+		return fmt.Errorf("cannot send marker: %w", err)
+	}
+
+	_, _, _, err = sh.readUntil(marker, false, motdTimeout)
+	if err != nil {
+		return fmt.Errorf("cannot find marker in the output of the shell: %w", err)
+	}
+
+	return nil
+}
+
+func (sh *Shell) drain(reader io.Reader, buffer *bytes.Buffer) {
+	defer sh.drained.Done()
+
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(chunk)
+		if n > 0 {
+			sh.mutex.Lock()
+			buffer.Write(chunk[:n])
+			sh.mutex.Unlock()
+
+			select {
+			case sh.updated <- struct{}{}:
+			default:
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (sh *Shell) readUntil(marker string, inStderr bool, timeout time.Duration) (string, string, string, error) {
+	// waits until the marker appears in stdout, and in stderr when inStderr is true
+	// returns and removes the stdout before the marker, the rest of the marker line, and the stderr before the marker
+	// remark that a timeout of 0 means no timeout
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		sh.mutex.Lock()
+		stdout, rest, stdoutNext, okStdout := splitMarker(sh.stdout.Bytes(), marker)
+		stderr, _, stderrNext, okStderr := splitMarker(sh.stderr.Bytes(), marker)
+		if !inStderr {
+			stderr, stderrNext, okStderr = sh.stderr.String(), sh.stderr.Len(), true
+		}
+		if okStdout && okStderr {
+			sh.stdout.Next(stdoutNext)
+			sh.stderr.Next(stderrNext)
+			sh.mutex.Unlock()
+			return stdout, rest, stderr, nil
+		}
+		sh.mutex.Unlock()
+
+		select {
+		case <-sh.updated:
+		case <-sh.finished:
+			// the output may have been updated just before the shell exited
+			select {
+			case <-sh.updated:
+				continue
+			default:
+			}
+			return "", "", "", errors.New("shell exited")
+		case <-expired:
+			return "", "", "", fmt.Errorf("timeout of %v expired", timeout)
+		}
+	}
+}
+
+func splitMarker(output []byte, marker string) (string, string, int, bool) {
+	// returns the output before the marker, the rest of the marker line, and the length up to and including the marker line
+	i := bytes.Index(output, []byte(marker))
+	if i < 0 {
+		return "", "", 0, false
+	}
+	j := bytes.IndexByte(output[i:], '\n')
+	if j < 0 {
+		return "", "", 0, false
+	}
+
+	rest := strings.TrimRight(string(output[i+len(marker):i+j]), "\r")
+	return string(output[:i]), rest, i + j + 1, true
+}
+
+//------------------------------------------------------------------------------
+
+func (sh *Shell) SendLine(line string) error {
+	_, err := io.WriteString(sh.stdin, line+"\n")
+	if err != nil {
+		return &Error{
+			command:  line,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/SendLine()] cannot send line: %#w\n", err),
+		}
+	}
+
+	return nil
+}
+
+func (sh *Shell) Send(text string) error {
+	// same as SendLine(), without adding a newline, f.i. to answer a prompt that reads single characters
+	_, err := io.WriteString(sh.stdin, text)
+	if err != nil {
+		return &Error{
+			command:  text,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/Send()] cannot send text: %#w\n", err),
+		}
+	}
+
+	return nil
+}
+
+func (sh *Shell) Expect(pattern string, timeout time.Duration) (string, error) {
+	// waits until the regular expression matches the stdout, f.i. to wait for a login prompt, and returns the matched text
+	// - the pattern uses golang's regexp syntax, f.i. "(?m)[$#] $" or "(?i)password: ?$"
+	// - the pattern is matched against the stdout received since the previous call of Expect() or Output(), it isn't anchored
+	// - the stdout up to and including the match is removed, so the next call of Expect() only matches newer output
+	// - a timeout of 0 means no timeout
+	// remark that the output may arrive in chunks, so a pattern matching a variable length, f.i. "\d+", may match
+	// a part of what is eventually received, anchor such a pattern to the text that follows it
+	// remark that with a pty, stderr is merged into stdout, without a pty stderr is not matched
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", &Error{
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/Expect()] cannot parse pattern %q: %#w\n", pattern, err),
+		}
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		sh.mutex.Lock()
+		if loc := re.FindIndex(sh.stdout.Bytes()); loc != nil {
+			matched := string(sh.stdout.Bytes()[loc[0]:loc[1]])
+			sh.stdout.Next(loc[1])
+			sh.mutex.Unlock()
+			return matched, nil
+		}
+		sh.mutex.Unlock()
+
+		select {
+		case <-sh.updated:
+		case <-sh.finished:
+			// the output may have been updated just before the shell exited
+			select {
+			case <-sh.updated:
+				continue
+			default:
+			}
+			return "", &Error{
+				exitCode: -1,
+				err:      fmt.Errorf("[golang-exec/runner/ssh/Expect()] shell exited before pattern %q matched\n", pattern),
+			}
+		case <-expired:
+			return "", &Error{
+				exitCode: -1,
+				err:      fmt.Errorf("[golang-exec/runner/ssh/Expect()] pattern %q didn't match within %v: %#w\n", pattern, timeout, context.DeadlineExceeded),
+			}
+		}
+	}
+}
+
+func (sh *Shell) ExecShell(shell string) error {
+	// replaces the login shell by another shell, and waits until that shell is ready to read commands
+	// remark that the login shell may read ahead of the "exec" command, so nothing else is sent before the new shell
+	// prints the marker
+	random := make([]byte, 8)
+	_, err := rand.Read(random)
+	if err != nil {
+		return &Error{
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/ExecShell()] cannot create marker: %#w\n", err),
+		}
+	}
+	marker := fmt.Sprintf("_exec-%x", random)
+
+	command := fmt.Sprintf("exec %[1]s -c 'echo %[2]s; exec %[1]s'", shell, marker)
+	err = sh.SendLine(command)
+	if err != nil {
+		return err
+	}
+
+	_, _, _, err = sh.readUntil(marker, false, motdTimeout)
+	if err != nil {
+		return &Error{
+			command:  command,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/ExecShell()] cannot start shell %q: %#w\n", shell, err),
+		}
+	}
+
+	return nil
+}
+
+func (sh *Shell) RunScript(s *script.Script, arguments interface{}) (string, string, int, error) {
+	// runs the rendered script in the shell, and returns its stdout, its stderr and its exit code
+	// the code is executed by the shell itself, so changes to the working directory and the environment persist
+	// remark that the code must be valid for the shell, must not read from stdin, and must not "exit" the shell
+	// remark that the end of the script is detected using a marker, and requires a posix shell
+	// remark that 'PreCommand' and 'PostCommand' are not supported, the bracket would exit the shell, see (*script.Script).bracket()
+	if s.Error != nil {
+		return "", "", -1, &Error{
+			script:   s,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/RunScript()] script failed to parse: %#w\n", s.Error),
+		}
+	}
+	if len(s.PreCommand) > 0 || len(s.PostCommand) > 0 {
+		return "", "", -1, &Error{
+			script:   s,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/RunScript()] 'PreCommand' and 'PostCommand' are not supported in a shell\n"),
+		}
+	}
+
+	code, err := s.Render(arguments)
+	if err != nil {
+		return "", "", -1, &Error{
+			script:   s,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/RunScript()] %#w\n", err),
+		}
+	}
+
+	random := make([]byte, 8)
+	_, err = rand.Read(random)
+	if err != nil {
+		return "", "", -1, &Error{
+			script:   s,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/RunScript()] cannot create marker: %#w\n", err),
+		}
+	}
+	marker := fmt.Sprintf("_end-%x", random)
+
+	// the exit code is saved first, because the echo commands overwrite it
+	if !strings.HasSuffix(code, "\n") {
+		code += "\n"
+	}
+	code += fmt.Sprintf("_golang_exec_status=$?; echo \"%[1]s $_golang_exec_status\"; echo \"%[1]s\" >&2\n", marker)
+
+	_, err = io.WriteString(sh.stdin, code)
+	if err != nil {
+		return "", "", -1, &Error{
+			script:   s,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/RunScript()] cannot send script: %#w\n", err),
+		}
+	}
+
+	stdout, rest, stderr, err := sh.readUntil(marker, true, 0)
+	if err != nil {
+		return stdout, stderr, -1, &Error{
+			script:   s,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/RunScript()] cannot find end of script: %#w\n", err),
+		}
+	}
+
+	exitCode, err := strconv.Atoi(strings.TrimSpace(rest))
+	if err != nil {
+		return stdout, stderr, -1, &Error{
+			script:   s,
+			exitCode: -1,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/RunScript()] cannot parse exit code %q: %#w\n", rest, err),
+		}
+	}
+	if exitCode != 0 {
+		return stdout, stderr, exitCode, &Error{
+			script:   s,
+			exitCode: exitCode,
+			err:      fmt.Errorf("[golang-exec/runner/ssh/RunScript()] script failed with exit code %d\n", exitCode),
+			stage:    StageCommand,
+		}
+	}
+
+	return stdout, stderr, 0, nil
+}
+
+func (sh *Shell) Output() (string, string) {
+	// returns the stdout and stderr received since the previous call
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	stdout := sh.stdout.String()
+	stderr := sh.stderr.String()
+	sh.stdout.Reset()
+	sh.stderr.Reset()
+
+	return stdout, stderr
+}
+
+func (sh *Shell) Close() error {
+	// closing stdin ends the shell, after which the remaining output is drained
+	_ = sh.stdin.Close()
+	_ = sh.session.Close()
+	sh.drained.Wait()
+
+	if sh.ownsClient {
+		sh.client.Close()
+	}
+
+	return nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,89 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package ssh
+
+import (
+	"bytes"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+const testMotd = "Welcome to the test server\nLast login: Mon Jan  1 00:00:00 2001\n"
+
+func TestRunWithoutMotd(t *testing.T) {
+	// an exec request doesn't start an interactive login, so the output of the runner is only the output of the command
+	srv, c := newTestServer(t)
+	srv.Motd = testMotd
+
+	r, err := New(c, newTestScript(t, "sh", "echo hello\n"), nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer r.Close()
+
+	var stdout bytes.Buffer
+	r.SetStdoutWriter(&stdout)
+
+	err = r.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if stdout.String() != "hello\n" {
+		t.Errorf("stdout = %q, want %q", stdout.String(), "hello\n")
+	}
+}
+
+func TestShellSuppressMotd(t *testing.T) {
+	srv, c := newTestServer(t)
+	srv.Motd = testMotd
+	c.SuppressMotd = true
+
+	sh, err := NewShell(c)
+	if err != nil {
+		t.Fatalf("NewShell() failed: %v", err)
+	}
+	defer sh.Close()
+
+	stdout, stderr, exitCode, err := sh.RunScript(newTestScript(t, "sh", "echo hello\n"), nil)
+	if err != nil {
+		t.Fatalf("RunScript() failed: %v", err)
+	}
+	if exitCode != 0 {
+		t.Errorf("exit code = %d, want 0", exitCode)
+	}
+	if stdout != "hello\n" {
+		t.Errorf("stdout = %q, want %q", stdout, "hello\n")
+	}
+	if stderr != "" {
+		t.Errorf("stderr = %q, want %q", stderr, "")
+	}
+}
+
+func TestShellRunScriptWithPostCommand(t *testing.T) {
+	// the bracket would exit the shell, hence it is an error instead of being dropped
+	_, c := newTestServer(t)
+
+	sh, err := NewShell(c)
+	if err != nil {
+		t.Fatalf("NewShell() failed: %v", err)
+	}
+	defer sh.Close()
+
+	s := newTestScript(t, "sh", "echo hello\n")
+	s.PostCommand = "echo done"
+
+	_, _, exitCode, err := sh.RunScript(s, nil)
+	if err == nil {
+		t.Fatal("RunScript() succeeded, want an error")
+	}
+	if exitCode != -1 {
+		t.Errorf("exit code = %d, want -1", exitCode)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -8,12 +8,17 @@ package script
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"os"
+	"reflect"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 )
@@ -22,20 +27,30 @@ import (
 
 type Script struct {
 	Name  string
-	Shell string // "cmd", powershell", "bash", "sh", ...
+	Shell string // "cmd", powershell", "bash", "sh", "fish", ..., or "raw"/"exec" to execute the rendered code as the command, see also NewArgv()
 
 	template *template.Template
+	argv     []*template.Template // for scripts created using NewArgv(), a template per argument
+	defaults interface{}
+
+	AllowEmpty bool     // allows running a script that renders to only whitespace, otherwise NewCommand() returns 'ErrEmptyScript'
+	ShellArgs  []string // overrides the arguments of the shell that reads the script from stdin, f.i. []string{"-s"}, see Command()
+
+	PreCommand  string // f.i. "mkdir /tmp/app.lock" to acquire a lock, runs before the rendered code, which only runs when it succeeds, see bracket()
+	PostCommand string // f.i. "rmdir /tmp/app.lock" to release the lock, runs after the rendered code, also when it fails, see bracket()
 
 	Error error // error from New()
 }
 
+var ErrEmptyScript = errors.New("[golang-exec/script] empty script")
+
 //------------------------------------------------------------------------------
 
 func New(name string, shell string, code string) *Script {
 	// remark that New() doesn't return any errors directly
 	// instead, error are saved in the 'Error'-field of the returned script
 	// this allows using New() in a package scope, while checking for errors in a function scope
-	template, err := template.New(name).Parse(code)
+	template, err := template.New(name).Funcs(templateFuncs(shell)).Parse(code)
 	if err != nil {
 		err = fmt.Errorf("[golang-exec/script/New()] cannot parse script: %#w\n", err)
 	}
@@ -54,7 +69,7 @@ func New(name string, shell string, code string) *Script {
 }
 
 func NewFromString(name string, shell string, code string) (*Script, error) {
-	template, err := template.New(name).Parse(code)
+	template, err := template.New(name).Funcs(templateFuncs(shell)).Parse(code)
 	if err != nil {
 		return nil, fmt.Errorf("[golang-exec/script/NewFromString()] cannot parse script: %#w\n", err)
 	}
@@ -68,7 +83,7 @@ func NewFromString(name string, shell string, code string) (*Script, error) {
 }
 
 func NewFromFile(name string, shell string, file string) (*Script, error) {
-	template, err := template.New(name).ParseFiles(file)
+	template, err := template.New(name).Funcs(templateFuncs(shell)).ParseFiles(file)
 	if err != nil {
 		return nil, fmt.Errorf("[golang-exec/script/NewFromFile()] cannot parse script: %#w\n", err)
 	}
@@ -81,12 +96,155 @@ func NewFromFile(name string, shell string, file string) (*Script, error) {
 	return s, nil
 }
 
+func NewArgv(name string, argv ...string) *Script {
+	// returns a script that executes the program argv[0] with the arguments argv[1:] directly, without a shell script,
+	// like exec.Command(), this is the safest way to run a fixed program with untrusted arguments
+	// - every argument is a template that is rendered separately, hence a rendered argument is always a single argument
+	// - the local runner executes the arguments directly, the ssh runner quotes every argument for the host's login shell
+	// remark that the shell of the script is "exec", and that the ssh runner assumes a posix login shell on the host
+	// remark that, like New(), errors are saved in the 'Error'-field of the returned script
+	s := new(Script)
+	s.Name = name
+
+	if len(argv) == 0 {
+		s.Error = fmt.Errorf("[golang-exec/script/NewArgv()] missing program in 'argv' parameter\n")
+		return s
+	}
+
+	templates := make([]*template.Template, len(argv))
+	for i, arg := range argv {
+		t, err := template.New(fmt.Sprintf("%s[%d]", name, i)).Parse(arg)
+		if err != nil {
+			s.Error = fmt.Errorf("[golang-exec/script/NewArgv()] cannot parse argument %d: %#w\n", i, err)
+			return s
+		}
+		templates[i] = t
+	}
+
+	s.Shell = "exec"
+	s.argv = templates
+
+	return s
+}
+
+func Compose(name string, shell string, parts ...string) *Script {
+	// returns the script for the parts joined with newlines, rendered as a single template
+	// - a shebang on the first line of the first part is kept as the first line of the script
+	// - a shebang on the first line of the other parts is removed, so complete scripts can be used as parts
+	// remark that, like New(), errors are saved in the 'Error'-field of the returned script
+	lines := make([]string, 0, len(parts))
+	for i, part := range parts {
+		part = strings.TrimRight(part, "\r\n")
+		if i > 0 && strings.HasPrefix(part, "#!") {
+			end := strings.IndexByte(part, '\n')
+			if end < 0 {
+				continue
+			}
+			part = part[end+1:]
+		}
+		lines = append(lines, part)
+	}
+
+	s := New(name, shell, strings.Join(lines, "\n")+"\n")
+	if s.Error != nil {
+		s.Error = fmt.Errorf("[golang-exec/script/Compose()] cannot parse composed script: %#w\n", errors.Unwrap(s.Error))
+	}
+
+	return s
+}
+
+func Quote(shell string, s string) string {
+	// returns s quoted as a single argument for the shell, f.i. to build a command string manually
+	// - "cmd": double quotes, with embedded double quotes doubled and "%" doubled for the temp batch file, see Command()
+	// - "powershell": single quotes, with embedded single quotes doubled, including the typographic ones powershell accepts
+	// - "fish": single quotes, with embedded single quotes and backslashes escaped by a backslash
+	// - other shells, "raw" and "exec": posix single quotes, with embedded single quotes as '\''
+	// remark that cmd cannot quote newlines, these end the command
+	switch strings.ToLower(shell) {
+	case "cmd":
+		s = strings.ReplaceAll(s, `"`, `""`)
+		s = strings.ReplaceAll(s, `%`, `%%`)
+		return `"` + s + `"`
+	case "powershell":
+		for _, q := range []string{"'", "\u2018", "\u2019", "\u201A", "\u201B"} {
+			s = strings.ReplaceAll(s, q, q+q)
+		}
+		return "'" + s + "'"
+	case "fish":
+		s = strings.ReplaceAll(s, `\`, `\\`)
+		s = strings.ReplaceAll(s, `'`, `\'`)
+		return "'" + s + "'"
+	default:
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+}
+
+func templateFuncs(shell string) template.FuncMap {
+	// returns the helpers that are available in the templates of a script, to render arguments as shell tokens
+	// - {{ quote .X }}: a value quoted as a single argument, a slice or an array as space-separated quoted arguments
+	// - {{ array .X }}: a slice or an array as an array literal
+	//   - "bash", "zsh" and "ksh": "('a' 'b')", f.i. "items={{ array .Items }}"
+	//   - "powershell": "@('a', 'b')", f.i. "$items = {{ array .Items }}"
+	//   - other shells, without array literals: the same as quote, f.i. "for item in {{ array .Items }}; do" for sh
+	// remark that without the helpers, a slice renders as golang's "[a b]", which is not useful in a shell
+	shell = strings.ToLower(shell)
+	return template.FuncMap{
+		"quote": func(v interface{}) string {
+			return strings.Join(quoteValues(shell, v), " ")
+		},
+		"array": func(v interface{}) string {
+			values := quoteValues(shell, v)
+			switch shell {
+			case "bash", "zsh", "ksh":
+				return "(" + strings.Join(values, " ") + ")"
+			case "powershell":
+				return "@(" + strings.Join(values, ", ") + ")"
+			default:
+				return strings.Join(values, " ")
+			}
+		},
+	}
+}
+
+func quoteValues(shell string, v interface{}) []string {
+	// returns the quoted elements of a slice or an array, or the quoted value for other types
+	if b, ok := v.([]byte); ok {
+		return []string{Quote(shell, string(b))}
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []string{Quote(shell, fmt.Sprint(v))}
+	}
+
+	values := make([]string, rv.Len())
+	for i := range values {
+		values[i] = Quote(shell, fmt.Sprint(rv.Index(i).Interface()))
+	}
+
+	return values
+}
+
 //------------------------------------------------------------------------------
 
 var seededRand *rand.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
 
 func (s *Script) Command() string {
 	// returns the command(s) to execute a script that is read from stdin
+	// the defaults per shell are
+	// - "cmd": the script is saved to a temp file that is executed, see below
+	// - "powershell": the script is saved to a temp file that is executed with "-File", see below
+	// - "fish": "fish", fish reads stdin when started without arguments
+	// - "raw" and "exec": the rendered code, see NewCommand()
+	// - other shells, f.i. "bash", "sh", "zsh" or "ksh": "<shell> -s", the posix option to read the commands from stdin
+	//
+	// the 'ShellArgs' replace the default for all shells except "raw" and "exec", f.i. for unusual shells
+	// remark that the arguments are joined with spaces, hence these cannot contain spaces themselves
+	// remark that the 'ShellArgs' are not used when the ssh runner stages the script to a temp file, see 'StageScript'
+	if len(s.ShellArgs) > 0 && s.Shell != "raw" && s.Shell != "exec" {
+		return s.Shell + " " + strings.Join(s.ShellArgs, " ")
+	}
+
 	var wd string
 	var spath string
 	switch s.Shell {
@@ -127,23 +285,292 @@ func (s *Script) Command() string {
         set E="!errorlevel!";
 		del "%s";
         exit $E"`, spath, spath, spath)
+	case "fish":
+		// for fish, we execute code directly from stdin, fish reads stdin when started without arguments
+		// remark that fish syntax differs from posix shells, f.i. "set -x NAME value" instead of "export NAME=value"
+		return "fish"
+	case "raw", "exec":
+		// for raw, the rendered code is the command, see NewCommand()
+		// remark that we can only render without arguments here
+		command, _ := s.Render(nil)
+		return command
 	default:
 		// for bash,... we execute code directly from stdin
-		return s.Shell + " -"
+		// remark that "-s" is the posix option to read the commands from stdin, it is supported consistently by bash, sh, dash, zsh and ksh
+		return s.Shell + " -s"
+	}
+}
+
+func (s *Script) NewCommand(arguments interface{}) (string, io.Reader, error) {
+	// returns the command to execute and the reader for its stdin
+	// for raw, the rendered code is the command and stdin is empty, otherwise stdin is the rendered code
+	// remark that a script that renders to only whitespace is an error, f.i. because of a mistake in a template, see 'AllowEmpty'
+	// remark that no error handling, like "set -e -o pipefail" or "$ErrorActionPreference = 'Stop'", is injected in the rendered code,
+	// a script that should stop at the first failing command must set this itself, see the examples
+	if s.Shell == "raw" || s.Shell == "exec" {
+		command, err := s.Render(arguments)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(command) == 0 && !s.AllowEmpty {
+			return "", nil, fmt.Errorf("[golang-exec/script/NewCommand()] script %q renders to an empty command: %#w\n", s.Name, ErrEmptyScript)
+		}
+		return command, new(bytes.Buffer), nil
+	}
+
+	stdin, err := s.NewReader(arguments)
+	if err != nil {
+		return "", nil, err
+	}
+	if b, ok := stdin.(*bytes.Buffer); ok && len(bytes.TrimSpace(b.Bytes())) == 0 && !s.AllowEmpty {
+		return "", nil, fmt.Errorf("[golang-exec/script/NewCommand()] script %q renders to only whitespace: %#w\n", s.Name, ErrEmptyScript)
+	}
+
+	return s.Command(), stdin, nil
+}
+
+func (s *Script) IsArgv() bool {
+	// returns true for a script created using NewArgv()
+	return s.argv != nil
+}
+
+func (s *Script) RenderArgv(arguments interface{}) ([]string, error) {
+	// returns the rendered arguments of a script created using NewArgv(), every template renders to exactly one argument
+	if s.argv == nil {
+		return nil, fmt.Errorf("[golang-exec/script/RenderArgv()] script %q is not created using NewArgv()\n", s.Name)
+	}
+	if len(s.PreCommand) > 0 || len(s.PostCommand) > 0 {
+		return nil, fmt.Errorf("[golang-exec/script/RenderArgv()] 'PreCommand' and 'PostCommand' are not supported for a script created using NewArgv()\n")
+	}
+
+	merged, err := s.mergeDefaults(arguments)
+	if err != nil {
+		return nil, fmt.Errorf("[golang-exec/script/RenderArgv()] %#w\n", err)
+	}
+
+	argv := make([]string, len(s.argv))
+	for i, t := range s.argv {
+		var rendered bytes.Buffer
+		err = t.Execute(&rendered, merged)
+		if err != nil {
+			return nil, fmt.Errorf("[golang-exec/script/RenderArgv()] cannot render argument %d: %#w\n", i, err)
+		}
+		argv[i] = rendered.String()
+	}
+
+	return argv, nil
+}
+
+func (s *Script) Render(arguments interface{}) (string, error) {
+	// returns the rendered code, for raw the leading and trailing whitespace is trimmed
+	// for a script created using NewArgv(), the rendered arguments are quoted for a posix shell and joined with spaces
+	// the code is bracketed by the 'PreCommand' and the 'PostCommand', like the code of NewReader(), see bracket()
+	if s.argv != nil {
+		argv, err := s.RenderArgv(arguments)
+		if err != nil {
+			return "", err
+		}
+		for i := range argv {
+			argv[i] = Quote("sh", argv[i])
+		}
+		return strings.Join(argv, " "), nil
+	}
+
+	var rendered bytes.Buffer
+	if s.template != nil {
+		merged, err := s.mergeDefaults(arguments)
+		if err != nil {
+			return "", fmt.Errorf("[golang-exec/script/Render()] %#w\n", err)
+		}
+
+		err = s.template.Execute(&rendered, merged)
+		if err != nil {
+			return "", fmt.Errorf("[golang-exec/script/Render()] cannot render script: %#w\n", err)
+		}
 	}
+
+	if len(s.PreCommand) > 0 || len(s.PostCommand) > 0 {
+		bracketed, err := s.bracket(rendered.String())
+		if err != nil {
+			return "", fmt.Errorf("[golang-exec/script/Render()] %#w\n", err)
+		}
+		return bracketed, nil
+	}
+
+	if s.Shell == "raw" || s.Shell == "exec" {
+		return strings.TrimSpace(rendered.String()), nil
+	}
+
+	return rendered.String(), nil
 }
 
 func (s *Script) NewReader(arguments interface{}) (io.Reader, error) {
 	// returns a reader for the parsed & rendered script
 	var rendered bytes.Buffer
 	if s.template != nil {
-		err := s.template.Execute(&rendered, arguments)
+		merged, err := s.mergeDefaults(arguments)
+		if err != nil {
+			return nil, fmt.Errorf("[golang-exec/script/NewReader()] %#w\n", err)
+		}
+
+		err = s.template.Execute(&rendered, merged)
 		if err != nil {
 			return nil, fmt.Errorf("[golang-exec/script/NewReader()] cannot render script: %#w\n", err)
 		}
 	}
 
+	if len(s.PreCommand) > 0 || len(s.PostCommand) > 0 {
+		bracketed, err := s.bracket(rendered.String())
+		if err != nil {
+			return nil, fmt.Errorf("[golang-exec/script/NewReader()] %#w\n", err)
+		}
+		return bytes.NewBufferString(bracketed), nil
+	}
+
 	return &rendered, nil
 }
 
+func (s *Script) bracket(code string) (string, error) {
+	// returns the code with the 'PreCommand' before and the 'PostCommand' after it, f.i. to acquire and release a lock
+	// - the code doesn't run when the pre-command fails, the script exits with the exit code of the pre-command
+	// - the post-command runs from a trap on EXIT, so it also runs when the code fails or exits early,
+	//   the exit code of the script is the exit code of the code
+	// remark that this is only supported for posix shells, f.i. "bash", "sh", "zsh" or "ksh"
+	// remark that a trap on EXIT in the code replaces the trap for the post-command
+	// remark that the post-command doesn't run when the shell is killed, f.i. by a timeout
+	switch s.Shell {
+	case "cmd", "powershell", "fish", "raw", "exec":
+		return "", fmt.Errorf("'PreCommand' and 'PostCommand' are not supported for shell %q", s.Shell)
+	}
+
+	var b strings.Builder
+	if len(s.PreCommand) > 0 {
+		b.WriteString("{\n" + strings.TrimRight(s.PreCommand, "\r\n") + "\n} || exit\n")
+	}
+	if len(s.PostCommand) > 0 {
+		b.WriteString("trap " + Quote(s.Shell, strings.TrimRight(s.PostCommand, "\r\n")) + " EXIT\n")
+	}
+	b.WriteString(code)
+
+	return b.String(), nil
+}
+
+func (s *Script) WithDefaults(defaults interface{}) *Script {
+	// sets the default template-arguments, a struct or a map, that are merged with the arguments when rendering
+	// - the arguments take precedence over the defaults
+	// - for a map, every key in the arguments takes precedence, also when its value is the zero value
+	// - for a struct, only fields in the arguments that are not the zero value take precedence
+	// - the merged arguments are rendered as a map, hence methods of a struct are not available in the template
+	// returns the script, to allow using WithDefaults() in a package scope together with New()
+	s.defaults = defaults
+	return s
+}
+
+func (s *Script) mergeDefaults(arguments interface{}) (interface{}, error) {
+	if s.defaults == nil {
+		return arguments, nil
+	}
+
+	merged := make(map[string]interface{})
+	err := mergeArguments(merged, s.defaults)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default arguments: %w", err)
+	}
+	err = mergeArguments(merged, arguments)
+	if err != nil {
+		return nil, fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	return merged, nil
+}
+
+func mergeArguments(merged map[string]interface{}, arguments interface{}) error {
+	if arguments == nil {
+		return nil
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(arguments))
+	switch v.Kind() {
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			merged[fmt.Sprint(iter.Key().Interface())] = iter.Value().Interface()
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if len(t.Field(i).PkgPath) > 0 {
+				continue // unexported, not accessible in the template
+			}
+			if _, ok := merged[t.Field(i).Name]; ok && v.Field(i).IsZero() {
+				continue
+			}
+			merged[t.Field(i).Name] = v.Field(i).Interface()
+		}
+	default:
+		return fmt.Errorf("must be a struct or a map, not %s", v.Kind())
+	}
+
+	return nil
+}
+
+func (s *Script) NewReaderJSON(arguments []byte) (io.Reader, error) {
+	// returns a reader for the parsed & rendered script, with the template-arguments decoded from a JSON object
+	var decoded map[string]interface{}
+	err := json.Unmarshal(arguments, &decoded)
+	if err != nil {
+		return nil, fmt.Errorf("[golang-exec/script/NewReaderJSON()] cannot decode JSON arguments: %#w\n", err)
+	}
+
+	return s.NewReader(decoded)
+}
+
+//------------------------------------------------------------------------------
+
+type Registry struct {
+	mutex   sync.RWMutex
+	scripts map[string]*Script
+}
+
+func NewRegistry() *Registry {
+	r := new(Registry)
+	r.scripts = make(map[string]*Script)
+
+	return r
+}
+
+func (r *Registry) Register(s *Script) error {
+	// scripts are keyed by their name, registering a second script with the same name is an error
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.scripts[s.Name]; ok {
+		return fmt.Errorf("[golang-exec/script/Register()] duplicate script name %q\n", s.Name)
+	}
+	r.scripts[s.Name] = s
+
+	return nil
+}
+
+func (r *Registry) Get(name string) (*Script, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	s, ok := r.scripts[name]
+	return s, ok
+}
+
+func (r *Registry) Names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	names := make([]string, 0, len(r.scripts))
+	for name := range r.scripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
 //------------------------------------------------------------------------------
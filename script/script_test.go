@@ -0,0 +1,158 @@
+//
+// Copyright (c) 2019 Stefaan Coussement
+// MIT License
+//
+// more info: https://github.com/stefaanc/golang-exec
+//
+package script
+
+import (
+	"io"
+	"os/exec"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		shell     string
+		shellArgs []string
+		command   string
+	}{
+		{"sh", nil, "sh -s"},
+		{"bash", nil, "bash -s"},
+		{"fish", nil, "fish"},
+		{"Fish", nil, "fish"},
+		{"fish", []string{"--no-config"}, "fish --no-config"},
+		{"zsh", []string{"-s", "-f"}, "zsh -s -f"},
+	}
+	for _, test := range tests {
+		s, err := NewFromString("test", test.shell, "echo hello\n")
+		if err != nil {
+			t.Fatalf("NewFromString() failed: %v", err)
+		}
+		s.ShellArgs = test.shellArgs
+
+		if command := s.Command(); command != test.command {
+			t.Errorf("%s %v: Command() = %q, want %q", test.shell, test.shellArgs, command, test.command)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		shell  string
+		s      string
+		quoted string
+	}{
+		{"sh", "", `''`},
+		{"sh", "hello world", `'hello world'`},
+		{"sh", "it's", `'it'\''s'`},
+		{"sh", `back\slash`, `'back\slash'`},
+		{"sh", "$HOME `id` \"x\"", "'$HOME `id` \"x\"'"},
+		{"sh", "line 1\nline 2", "'line 1\nline 2'"},
+		{"bash", "it's", `'it'\''s'`},
+		{"raw", "it's", `'it'\''s'`},
+		{"exec", "it's", `'it'\''s'`},
+
+		{"cmd", "", `""`},
+		{"cmd", "hello world", `"hello world"`},
+		{"cmd", `say "hi"`, `"say ""hi"""`},
+		{"cmd", "100%", `"100%%"`},
+		{"cmd", "%PATH%", `"%%PATH%%"`},
+		{"cmd", `C:\temp\`, `"C:\temp\"`},
+		{"cmd", "it's", `"it's"`},
+		{"cmd", "line 1\nline 2", "\"line 1\nline 2\""}, // passed through, cmd cannot quote newlines
+
+		{"powershell", "", `''`},
+		{"powershell", "it's", `'it''s'`},
+		{"powershell", "it\u2019s", "'it\u2019\u2019s'"},
+		{"powershell", "\u2018quoted\u2019", "'\u2018\u2018quoted\u2019\u2019'"},
+		{"powershell", "\u201Alow\u201B", "'\u201A\u201Alow\u201B\u201B'"},
+		{"powershell", `$env:PATH "x" \`, `'$env:PATH "x" \'`},
+		{"powershell", "line 1\nline 2", "'line 1\nline 2'"},
+		{"PowerShell", "it's", `'it''s'`},
+
+		{"fish", "", `''`},
+		{"fish", "it's", `'it\'s'`},
+		{"fish", `back\slash`, `'back\\slash'`},
+		{"fish", `\'`, `'\\\''`},
+		{"fish", "$HOME (id) \"x\"", "'$HOME (id) \"x\"'"},
+		{"fish", "line 1\nline 2", "'line 1\nline 2'"},
+	}
+	for _, test := range tests {
+		if quoted := Quote(test.shell, test.s); quoted != test.quoted {
+			t.Errorf("Quote(%q, %q) = %s, want %s", test.shell, test.s, quoted, test.quoted)
+		}
+	}
+}
+
+func TestQuotePosixRoundTrip(t *testing.T) {
+	// the quoted string must be a single argument that the shell passes through unchanged
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	for _, s := range []string{"", "it's", "''", `back\slash`, "$HOME `id` \"x\" $(id)", "line 1\nline 2\n", "* ? [a] ~ ; & |"} {
+		output, err := exec.Command("sh", "-c", "printf %s "+Quote("sh", s)).Output()
+		if err != nil {
+			t.Fatalf("sh failed for %q: %v", s, err)
+		}
+		if string(output) != s {
+			t.Errorf("sh printed %q for %q", string(output), s)
+		}
+	}
+}
+
+func TestPreAndPostCommand(t *testing.T) {
+	// the code is bracketed the same way by Render() and NewReader(), and scripts that cannot be bracketed are an error
+	s, err := NewFromString("test", "sh", "echo {{.}}\n")
+	if err != nil {
+		t.Fatalf("NewFromString() failed: %v", err)
+	}
+	s.PreCommand = "mkdir /tmp/app.lock"
+	s.PostCommand = "rmdir /tmp/app.lock"
+	want := "{\nmkdir /tmp/app.lock\n} || exit\ntrap 'rmdir /tmp/app.lock' EXIT\necho hello\n"
+
+	rendered, err := s.Render("hello")
+	if err != nil {
+		t.Fatalf("Render() failed: %v", err)
+	}
+	if rendered != want {
+		t.Errorf("Render() = %q, want %q", rendered, want)
+	}
+
+	reader, err := s.NewReader("hello")
+	if err != nil {
+		t.Fatalf("NewReader() failed: %v", err)
+	}
+	b, _ := io.ReadAll(reader)
+	if string(b) != want {
+		t.Errorf("NewReader() = %q, want %q", string(b), want)
+	}
+
+	unsupported := map[string]*Script{
+		"raw":  New("test", "raw", "echo hello"),
+		"exec": New("test", "exec", "echo hello"),
+		"fish": New("test", "fish", "echo hello\n"),
+		"argv": NewArgv("test", "echo", "hello"),
+	}
+	for name, s := range unsupported {
+		s.PostCommand = "rmdir /tmp/app.lock"
+
+		if _, err := s.Render(nil); err == nil {
+			t.Errorf("%s: Render() succeeded, want an error", name)
+		}
+		if _, _, err := s.NewCommand(nil); err == nil {
+			t.Errorf("%s: NewCommand() succeeded, want an error", name)
+		}
+		if s.IsArgv() {
+			if _, err := s.RenderArgv(nil); err == nil {
+				t.Errorf("%s: RenderArgv() succeeded, want an error", name)
+			}
+		}
+	}
+}
+
+//------------------------------------------------------------------------------